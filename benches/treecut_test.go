@@ -99,3 +99,43 @@ func BenchmarkMakePartitions(b *testing.B) {
 		})
 	}
 }
+
+func BenchmarkWalkWorkers(b *testing.B) {
+	// Walking dominates planning with the count strategy, which creates nothing on disk
+	sourceDir := b.TempDir()
+	for i := 0; i < 200; i++ {
+		dir := filepath.Join(sourceDir, fmt.Sprintf("dir%d", i/20), fmt.Sprintf("sub%d", i))
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			b.Fatalf("error creating directory: %v", err)
+		}
+
+		for j := 0; j < 50; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", j)), []byte("content"), os.ModePerm); err != nil {
+				b.Fatalf("error creating file: %v", err)
+			}
+		}
+	}
+
+	for _, workers := range []int{1, 2, 4, 8, 0} {
+		name := fmt.Sprintf("%d workers", workers)
+		if workers == 0 {
+			name = "GOMAXPROCS workers"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			config := trc.PartitionConfig{
+				SourceDir:    sourceDir,
+				OutputDirs:   []string{filepath.Join(b.TempDir(), "partition1"), filepath.Join(b.TempDir(), "partition2")},
+				ByFile:       true,
+				PreserveTree: true,
+				WalkWorkers:  workers,
+			}
+
+			for i := 0; i < b.N; i++ {
+				if _, err := trc.Plan(config); err != nil {
+					b.Fatalf("cannot plan partitions: %v", err)
+				}
+			}
+		})
+	}
+}
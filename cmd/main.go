@@ -1,32 +1,133 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ezrantn/trc"
 	"github.com/ezrantn/trc/internal/cli"
 )
 
 func main() {
-	config, unlink, err := cli.ParseCLI()
+	opts, err := cli.ParseCLI()
 	if err != nil {
 		cli.PrintError(err)
 		os.Exit(1)
 	}
 
-	if unlink {
+	// An interrupted run is rolled back, so the partitions are left as they were before it started
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case opts.Unlink:
 		fmt.Println("Removing partitions and symlinks...")
-		if err := trc.RemovePartitions(config.OutputDirs); err != nil {
-			fmt.Println("Error removing partitions:", err)
+		if err := trc.RemovePartitionsContext(ctx, opts.Config); err != nil {
+			cli.PrintError(fmt.Errorf("removing partitions: %w", err))
 			os.Exit(1)
 		}
 
 		fmt.Println("Partitions removed sucessfully")
-	} else {
+
+	case opts.Verify:
+		report, err := trc.VerifyPartitions(opts.Config)
+		if err != nil {
+			cli.PrintError(fmt.Errorf("verifying partitions: %w", err))
+			os.Exit(1)
+		}
+
+		cli.PrintVerifyReport(os.Stdout, report)
+		if !report.OK() {
+			os.Exit(1)
+		}
+
+	case opts.Sync:
+		fmt.Println("Syncing partitions...")
+		report, err := trc.SyncPartitionsContext(ctx, opts.Config)
+		if report == nil {
+			cli.PrintError(fmt.Errorf("syncing partitions: %w", err))
+			os.Exit(1)
+		}
+
+		fmt.Printf("Partitions synced: %d added, %d removed, %d unchanged\n", report.Added, report.Removed, report.Unchanged)
+
+		// With --continue-on-error the files that failed are reported once the others are synced
+		if err != nil {
+			cli.PrintError(fmt.Errorf("syncing partitions: %w", err))
+			os.Exit(1)
+		}
+
+	case opts.PlanFile != "":
+		plan, err := trc.LoadPlan(opts.PlanFile)
+		if err != nil {
+			cli.PrintError(fmt.Errorf("loading plan: %w", err))
+			os.Exit(1)
+		}
+
+		if opts.LinkMode != nil {
+			plan.Config.LinkMode = *opts.LinkMode
+		}
+
+		if opts.Config.Atomic {
+			plan.Config.Atomic = true
+		}
+
+		if opts.Config.ContinueOnError {
+			plan.Config.ContinueOnError = true
+		}
+		plan.Config.OnProgress = opts.Config.OnProgress
+		plan.Config.Logger = opts.Config.Logger
+
+		fmt.Println("Applying plan...")
+		if err := plan.ApplyContext(ctx); err != nil {
+			cli.PrintError(fmt.Errorf("applying plan: %w", err))
+			os.Exit(1)
+		}
+
+		fmt.Println("Partitions created sucessfully")
+
+	case opts.Config.Stream:
+		fmt.Println("Creating partitions...")
+		if err := trc.MakePartitionsContext(ctx, opts.Config); err != nil {
+			cli.PrintError(fmt.Errorf("creating partitions: %w", err))
+			os.Exit(1)
+		}
+
+		fmt.Println("Partitions created sucessfully")
+
+	default:
+		plan, err := trc.PlanContext(ctx, opts.Config)
+		if plan == nil {
+			cli.PrintError(fmt.Errorf("planning partitions: %w", err))
+			os.Exit(1)
+		}
+
+		// With --continue-on-error the files that could not be planned are reported once the others are linked
+		failed := err
+
+		if opts.SavePlan != "" {
+			if err := plan.Save(opts.SavePlan); err != nil {
+				cli.PrintError(fmt.Errorf("saving plan: %w", err))
+				os.Exit(1)
+			}
+		}
+
+		if opts.DryRun {
+			cli.PrintPlan(os.Stdout, plan)
+			if failed != nil {
+				cli.PrintError(fmt.Errorf("planning partitions: %w", failed))
+				os.Exit(1)
+			}
+			return
+		}
+
 		fmt.Println("Creating partitions...")
-		if err := trc.MakePartitions(config); err != nil {
-			fmt.Println("Error creating partitions:", err)
+		if err := errors.Join(failed, plan.ApplyContext(ctx)); err != nil {
+			cli.PrintError(fmt.Errorf("creating partitions: %w", err))
 			os.Exit(1)
 		}
 
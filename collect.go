@@ -1,23 +1,20 @@
 package trc
 
 import (
-	"errors"
-	"fmt"
-	"io/fs"
-	"os"
+	"context"
 	"path/filepath"
 	"regexp"
 	"strings"
-
-	"github.com/gabriel-vasile/mimetype"
 )
 
 type fileInfo struct {
-	path string
-	size int64
+	path   string
+	size   int64
+	name   string // Sanitized name to link the file as, its path below the partition with PreserveTree
+	target string // Real file to link to when path goes through followed symlinks
 }
 
-// Reserved characters and words for filename validation
+// Reserved characters and words for filename validation, used by the FAT and Windows name profiles
 // See: https://en.wikipedia.org/wiki/Filename#Reserved_characters_and_words
 const (
 	characterFilter      = `[\x00-\x1F\\/:*?"<>|@!]` // Some FAT systems don't allow @ and ! in filenames
@@ -31,45 +28,6 @@ var (
 	characterFilterRegex = regexp.MustCompile(characterFilter)
 )
 
-// isValidFileName ensures a file is not using reserved names and meets system constraints
-func isValidFileName(filename string) (bool, error) {
-	if hasTrailingDotOrSpace(filename) {
-		return false, errors.New("filename has trailing dots or spaces")
-	}
-
-	filename = strings.TrimSpace(filename)
-
-	if filename == "" {
-		return false, errors.New("filename cannot be empty")
-	}
-
-	if len(filename) > maxLength {
-		return false, errors.New("filename exceeds maximum length")
-	}
-
-	if characterFilterRegex.MatchString(filename) {
-		return false, errors.New("filename contains invalid characters")
-	}
-
-	baseName := filenameWithoutExtension(filename)
-
-	if isReservedName(baseName) {
-		return false, errors.New("filename is a reserved name")
-	}
-
-	return true, nil
-}
-
-func isReservedName(filename string) bool {
-	reservedNames := strings.Split(dosReservedNames+" "+windowsReservedNames, " ")
-	for _, reservedName := range reservedNames {
-		if strings.EqualFold(filename, reservedName) {
-			return true
-		}
-	}
-	return false
-}
-
 func filenameWithoutExtension(filename string) string {
 	return strings.TrimSuffix(filename, filepath.Ext(filename))
 }
@@ -78,114 +36,45 @@ func hasTrailingDotOrSpace(filename string) bool {
 	return strings.HasSuffix(filename, ".") || strings.HasSuffix(filename, " ")
 }
 
-func collectFiles(sourceDir string) ([]string, error) {
-	filesChan := make(chan string, 100)
-	errChan := make(chan error, 1)
-
-	go func() {
-		defer close(filesChan)
-		errChan <- filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if !d.IsDir() {
-				baseName := filepath.Base(path)
-				if ok, _ := isValidFileName(baseName); !ok {
-					return errors.New("invalid file name: " + baseName)
-				}
-
-				filesChan <- path
-			}
-			return nil
-		})
-
-		close(errChan)
-	}()
-
-	// Collect results
-	var files []string
-	for file := range filesChan {
-		files = append(files, file)
-	}
-
-	// Return any errors encountered
-	if err, ok := <-errChan; ok && err != nil {
-		return nil, err
-	}
-
-	return files, nil
+// isManifestDir reports whether path is a trc metadata directory below sourceDir, which is never partitioned.
+func isManifestDir(sourceDir, path string, isDir bool) bool {
+	return isDir && path != sourceDir && filepath.Base(path) == ManifestDir
 }
 
-func collectFilesWithSize(sourceDir string) ([]fileInfo, error) {
-	filesChan := make(chan fileInfo, 100)
-	errChan := make(chan error, 1)
+// collectFilesWithSize collects the files selected by filter from the source directory with their sizes.
+// A nil filter selects every file.
+func collectFilesWithSize(ctx context.Context, sourceDir string, filter *fileFilter) ([]fileInfo, error) {
 	var files []fileInfo
 
-	go func() {
-		defer close(filesChan)
-		err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if !d.IsDir() {
-				info, err := d.Info()
-				if err != nil {
-					return err
-				}
-
-				if ok, _ := isValidFileName(filepath.Base(path)); !ok {
-					return errors.New("invalid file name: " + path)
-				}
-
-				filesChan <- fileInfo{path: path, size: info.Size()}
-			}
-
-			return nil
-		})
-		errChan <- err
-		close(errChan)
-	}()
-
-	// Read from filesChan
-	for file := range filesChan {
-		files = append(files, file)
-	}
+	err := walkSource(ctx, sourceDir, filter, false, func(file sourceFile) error {
+		name, ok, err := filter.linkName(file.path)
+		if !ok {
+			return err
+		}
 
-	// Return error if any
-	if err := <-errChan; err != nil {
+		files = append(files, fileInfo{path: file.path, size: file.info.Size(), name: name, target: file.target})
+		return nil
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
 	return files, nil
 }
 
-// collectFilesWithMimeType collects files from the source directory and categorizes them by MIME type
-func collectFilesWithMimeType(sourceDir string) (map[string][]string, error) {
-	mimeMap := make(map[string][]string)
+// collectFilesWithMimeType collects the files selected by filter from the source directory and
+// categorizes them by MIME type. A nil filter selects every file.
+func collectFilesWithMimeType(ctx context.Context, sourceDir string, filter *fileFilter) (map[string][]fileInfo, error) {
+	mimeMap := make(map[string][]fileInfo)
 
-	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	err := walkSource(ctx, sourceDir, filter, true, func(file sourceFile) error {
+		name, ok, err := filter.linkName(file.path)
+		if !ok {
 			return err
 		}
 
-		if info.IsDir() || info.Size() == 0 {
-			return nil
-		}
-
-		// Detect MIME type using third-party library
-		mtype, err := mimetype.DetectFile(path)
-		if err != nil {
-			fmt.Printf("Failed to detect MIME for %s: %v\n", path, err)
-			return nil
-		}
-
-		// Extract the category (e.g., "image", "video", etc.)
-		mainType := mtype.String()
-		category := mainType[:strings.Index(mainType, "/")]
-		mimeMap[category] = append(mimeMap[category], path)
-
+		mimeMap[file.category] = append(mimeMap[file.category], fileInfo{path: file.path, size: file.info.Size(), name: name, target: file.target})
 		return nil
 	})
 
@@ -1,6 +1,7 @@
 package trc
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -86,8 +87,8 @@ func TestCollectFiles(t *testing.T) {
 				}
 			}
 
-			// Run collectFiles
-			files, err := collectFiles(testDir)
+			// Run the collector
+			files, err := collectFilesWithSize(context.Background(), testDir, nil)
 
 			t.Logf("%v", tt.expectError)
 
@@ -207,7 +208,7 @@ func TestCollectFilesBySize(t *testing.T) {
 			}
 
 			// Run the function
-			files, err := collectFilesWithSize(testDir)
+			files, err := collectFilesWithSize(context.Background(), testDir, nil)
 
 			if tt.expectError {
 				if err == nil {
@@ -267,8 +268,10 @@ func TestIsValidName(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			if got, _ := isValidFileName(test.input); got != test.expected {
-				t.Errorf("isValidFileName(%q) = %v; want %v", test.input, got, test.expected)
+			// The FAT profile is the strictest one
+			err := nameProfileRules[NameProfileFAT].validate(test.input)
+			if got := err == nil; got != test.expected {
+				t.Errorf("validate(%q) = %v; want valid %v", test.input, err, test.expected)
 			}
 		})
 	}
@@ -332,7 +335,7 @@ func TestCollectFilesWithMimeType(t *testing.T) {
 		}
 	}
 
-	result, err := collectFilesWithMimeType(testDir)
+	result, err := collectFilesWithMimeType(context.Background(), testDir, nil)
 	if err != nil {
 		t.Fatalf("collectFilesWithMimeType returned an error: %v", err)
 	}
@@ -353,7 +356,7 @@ func TestCollectFilesWithMimeType(t *testing.T) {
 	// Verify empty file was skipped
 	for _, files := range result {
 		for _, file := range files {
-			if filepath.Base(file) == "empty.txt" {
+			if filepath.Base(file.path) == "empty.txt" {
 				t.Errorf("empty.txt should be skipped but was found in results")
 			}
 		}
@@ -369,7 +372,7 @@ func TestFileInCategory(t *testing.T) {
 		t.Fatalf("failed to create test file: %v", err)
 	}
 
-	result, err := collectFilesWithMimeType(testDir)
+	result, err := collectFilesWithMimeType(context.Background(), testDir, nil)
 	if err != nil {
 		t.Fatalf("collectFilesWithMimeType returned an error: %v", err)
 	}
@@ -378,7 +381,7 @@ func TestFileInCategory(t *testing.T) {
 	if files, ok := result["text"]; ok {
 		found := false
 		for _, file := range files {
-			if strings.HasSuffix(file, "sample.txt") {
+			if strings.HasSuffix(file.path, "sample.txt") {
 				found = true
 				break
 			}
@@ -0,0 +1,256 @@
+package trc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CollisionPolicy decides what happens when two files map to the same link inside a partition.
+type CollisionPolicy int
+
+const (
+	CollisionFail         CollisionPolicy = iota // Abort with an error (default)
+	CollisionSkip                                // Keep the existing link and skip the new file
+	CollisionRenameSuffix                        // Rename the new link with a numeric suffix (report_1.csv)
+	CollisionRenameHash                          // Rename the new link with a short hash of its source (report_1a2b3c4d.csv)
+	CollisionOverwrite                           // Replace the existing link with the new one
+)
+
+// ErrCollision is returned when two files map to the same link and the policy is CollisionFail.
+var ErrCollision = errors.New("link name collision")
+
+var collisionPolicyNames = map[CollisionPolicy]string{
+	CollisionFail:         "fail",
+	CollisionSkip:         "skip",
+	CollisionRenameSuffix: "suffix",
+	CollisionRenameHash:   "hash",
+	CollisionOverwrite:    "overwrite",
+}
+
+// String returns the name of the policy as accepted by ParseCollisionPolicy.
+func (p CollisionPolicy) String() string {
+	return enumString(collisionPolicyNames, "CollisionPolicy", p)
+}
+
+func (p CollisionPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *CollisionPolicy) UnmarshalText(text []byte) error {
+	return unmarshalEnum(p, text, ParseCollisionPolicy)
+}
+
+// ParseCollisionPolicy converts a policy name (fail, skip, suffix, hash, overwrite) into a CollisionPolicy.
+func ParseCollisionPolicy(name string) (CollisionPolicy, error) {
+	return parseEnum(collisionPolicyNames, "collision policy", name)
+}
+
+// Collision describes a file whose link path was already taken inside its partition.
+type Collision struct {
+	Partition int             // Index of the partition in OutputDirs
+	LinkPath  string          // Link path the file originally mapped to
+	Source    string          // File that could not take LinkPath
+	Existing  string          // What LinkPath already pointed to (empty if it is not a symlink)
+	Resolved  string          // Link path actually used, empty if the file was skipped or the run failed
+	Policy    CollisionPolicy // Policy that was applied
+}
+
+// CollisionError is returned when two files map to the same link and the policy is
+// CollisionFail, or when CollisionOverwrite would replace something that is not a symlink. It
+// matches ErrCollision with errors.Is.
+type CollisionError struct {
+	Path      string          // Link path both files map to
+	Partition int             // Index of the partition in OutputDirs
+	Source    string          // File that could not take Path
+	Existing  string          // What Path already pointed to (empty if it is not a symlink)
+	Policy    CollisionPolicy // Policy that was applied
+}
+
+func (e *CollisionError) Error() string {
+	switch {
+	case e.Policy == CollisionOverwrite:
+		return fmt.Sprintf("%v: refusing to overwrite %s, it is not a symlink", ErrCollision, e.Path)
+	case e.Existing == "":
+		return fmt.Sprintf("%v: %s already exists, cannot link %s there", ErrCollision, e.Path, e.Source)
+	}
+	return fmt.Sprintf("%v: %s and %s both map to %s", ErrCollision, e.Existing, e.Source, e.Path)
+}
+
+func (e *CollisionError) Unwrap() error {
+	return ErrCollision
+}
+
+// collisionResolver tracks the link paths claimed in each partition during a run and applies
+// the configured CollisionPolicy when a link path is requested twice.
+type collisionResolver struct {
+	config PartitionConfig
+	linker Linker
+
+	mu      sync.Mutex          // Streaming runs release claims from the link workers
+	claimed []map[string]string // link path -> source, one map per partition
+}
+
+func newCollisionResolver(config PartitionConfig) *collisionResolver {
+	claimed := make([]map[string]string, len(config.OutputDirs))
+	for i := range claimed {
+		claimed[i] = make(map[string]string)
+	}
+
+	// An unknown link mode is reported by Apply, plan as if linking with symlinks meanwhile
+	linker, err := newLinker(config.LinkMode, config.RelativeLinks)
+	if err != nil {
+		linker = symlinkLinker{relative: config.RelativeLinks}
+	}
+
+	return &collisionResolver{config: config, linker: linker, claimed: claimed}
+}
+
+// resolve returns the link path that source should use inside the given partition. An empty
+// path means the file must be skipped. Existing symlinks that already point to source, and files
+// the Linker recognizes as placements of source, are not collisions, so running the same
+// partitioning twice is idempotent.
+func (r *collisionResolver) resolve(partition int, linkPath, source string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, taken := r.lookup(partition, linkPath, source)
+	if !taken {
+		r.claimed[partition][linkPath] = source
+		return linkPath, nil
+	}
+
+	collision := Collision{
+		Partition: partition,
+		LinkPath:  linkPath,
+		Source:    source,
+		Existing:  existing,
+		Policy:    r.config.CollisionPolicy,
+	}
+
+	switch r.config.CollisionPolicy {
+	case CollisionSkip:
+		r.report(collision)
+		return "", nil
+
+	case CollisionOverwrite:
+		if existing == "" {
+			// Only links are ever replaced, regular files and directories are left alone
+			r.report(collision)
+			return "", &CollisionError{Path: linkPath, Partition: partition, Source: source, Policy: collision.Policy}
+		}
+
+		collision.Resolved = linkPath
+		r.claimed[partition][linkPath] = source
+		r.report(collision)
+		return linkPath, nil
+
+	case CollisionRenameSuffix, CollisionRenameHash:
+		resolved := r.rename(partition, linkPath, source)
+		collision.Resolved = resolved
+		r.claimed[partition][resolved] = source
+		r.report(collision)
+		return resolved, nil
+
+	default:
+		r.report(collision)
+		return "", &CollisionError{Path: linkPath, Partition: partition, Source: source, Existing: existing, Policy: collision.Policy}
+	}
+}
+
+// forget releases the claim of source on linkPath once its link exists, where lookup finds it
+// on disk, so streaming runs do not remember every link they created.
+func (r *collisionResolver) forget(partition int, linkPath, source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.claimed[partition][linkPath] == source {
+		delete(r.claimed[partition], linkPath)
+	}
+}
+
+// lookup reports whether linkPath is already used by something other than source, either
+// earlier in this run or on disk, and what it currently points to.
+func (r *collisionResolver) lookup(partition int, linkPath, source string) (string, bool) {
+	if claimedBy, ok := r.claimed[partition][linkPath]; ok {
+		return claimedBy, claimedBy != source
+	}
+
+	if _, err := os.Lstat(linkPath); err != nil {
+		return "", false
+	}
+
+	target, err := resolveSymlink(linkPath)
+	if err != nil {
+		return "", !r.linker.Same(source, linkPath)
+	}
+
+	return target, target != absPath(source)
+}
+
+// rename finds the first free variant of linkPath according to the rename policy.
+func (r *collisionResolver) rename(partition int, linkPath, source string) string {
+	if r.config.CollisionPolicy == CollisionRenameHash {
+		candidate := withNameTag(linkPath, r.shortHash(source))
+		if _, taken := r.lookup(partition, candidate, source); !taken {
+			return candidate
+		}
+		linkPath = candidate
+	}
+
+	for n := 1; ; n++ {
+		candidate := withNameTag(linkPath, strconv.Itoa(n))
+		if _, taken := r.lookup(partition, candidate, source); !taken {
+			return candidate
+		}
+	}
+}
+
+// shortHash returns the first 8 hex characters of the SHA-256 of the source path relative to
+// the source directory, so the same file gets the same name on every machine.
+func (r *collisionResolver) shortHash(source string) string {
+	key := source
+	if relPath, err := filepath.Rel(r.config.SourceDir, source); err == nil {
+		key = filepath.ToSlash(relPath)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// report hands a collision to OnCollision and logs it, unless it fails the run and is returned
+// as an error instead.
+func (r *collisionResolver) report(collision Collision) {
+	if r.config.OnCollision != nil {
+		r.config.OnCollision(collision)
+	}
+
+	log := newLogger(r.config)
+	switch {
+	case collision.Policy == CollisionSkip:
+		log.Warn("skipped file, its link path is taken", "path", collision.LinkPath, "source", collision.Source,
+			"existing", collision.Existing, "partition", collision.Partition)
+	case collision.Resolved != "":
+		log.Warn("link path collision", "path", collision.LinkPath, "source", collision.Source,
+			"existing", collision.Existing, "partition", collision.Partition, "resolved", collision.Resolved, "policy", collision.Policy)
+	}
+}
+
+// withNameTag inserts "_tag" between the file name and its extension.
+func withNameTag(path, tag string) string {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		// Dot files such as .bashrc have no real extension
+		stem, ext = name, ""
+	}
+
+	return dir + stem + "_" + tag + ext
+}
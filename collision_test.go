@@ -0,0 +1,160 @@
+package trc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollisionPolicies(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        CollisionPolicy
+		expectedErr   bool
+		expectedLinks map[string]string // link name -> source relative to the source dir
+		collisions    int
+	}{
+		{
+			name:        "fail",
+			policy:      CollisionFail,
+			expectedErr: true,
+			collisions:  1,
+		},
+		{
+			name:          "skip",
+			policy:        CollisionSkip,
+			expectedLinks: map[string]string{"report.csv": filepath.Join("a", "report.csv")},
+			collisions:    1,
+		},
+		{
+			name:   "rename with suffix",
+			policy: CollisionRenameSuffix,
+			expectedLinks: map[string]string{
+				"report.csv":   filepath.Join("a", "report.csv"),
+				"report_1.csv": filepath.Join("b", "report.csv"),
+			},
+			collisions: 1,
+		},
+		{
+			name:          "overwrite",
+			policy:        CollisionOverwrite,
+			expectedLinks: map[string]string{"report.csv": filepath.Join("b", "report.csv")},
+			collisions:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			outputDir := filepath.Join(tempDir, "partition1")
+
+			var files []fileInfo
+			for _, dir := range []string{"a", "b"} {
+				path := filepath.Join(sourceDir, dir, "report.csv")
+				if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+					t.Fatalf("error creating directory: %v", err)
+				}
+
+				if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+				files = append(files, fileInfo{path: path, size: 7})
+			}
+
+			var collisions []Collision
+			config := PartitionConfig{
+				SourceDir:       sourceDir,
+				OutputDirs:      []string{outputDir},
+				CollisionPolicy: tt.policy,
+				OnCollision:     func(c Collision) { collisions = append(collisions, c) },
+			}
+
+			err := createSymlinkTree([][]fileInfo{files}, config)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error: %v, got: %v", tt.expectedErr, err)
+			}
+
+			if tt.expectedErr && !errors.Is(err, ErrCollision) {
+				t.Errorf("expected ErrCollision, got: %v", err)
+			}
+
+			if len(collisions) != tt.collisions {
+				t.Errorf("expected %d collisions to be reported, got %d", tt.collisions, len(collisions))
+			}
+
+			for name, source := range tt.expectedLinks {
+				target, err := os.Readlink(filepath.Join(outputDir, name))
+				if err != nil {
+					t.Errorf("link %s not created: %v", name, err)
+					continue
+				}
+
+				if target != filepath.Join(sourceDir, source) {
+					t.Errorf("link %s points to %s, expected %s", name, target, filepath.Join(sourceDir, source))
+				}
+			}
+		})
+	}
+}
+
+func TestCollisionRenameHashIsStable(t *testing.T) {
+	config := PartitionConfig{
+		SourceDir:       "source",
+		OutputDirs:      []string{"partition1"},
+		CollisionPolicy: CollisionRenameHash,
+	}
+
+	first := newCollisionResolver(config)
+	second := newCollisionResolver(config)
+
+	for _, resolver := range []*collisionResolver{first, second} {
+		if _, err := resolver.resolve(0, filepath.Join("partition1", "report.csv"), filepath.Join("source", "a", "report.csv")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	firstName, _ := first.resolve(0, filepath.Join("partition1", "report.csv"), filepath.Join("source", "b", "report.csv"))
+	secondName, _ := second.resolve(0, filepath.Join("partition1", "report.csv"), filepath.Join("source", "b", "report.csv"))
+
+	if firstName != secondName {
+		t.Errorf("hash rename is not stable: %s vs %s", firstName, secondName)
+	}
+
+	if firstName == filepath.Join("partition1", "report.csv") {
+		t.Errorf("expected the colliding link to be renamed, got %s", firstName)
+	}
+}
+
+func TestWithNameTag(t *testing.T) {
+	tests := []struct {
+		input    string
+		tag      string
+		expected string
+	}{
+		{"report.csv", "1", "report_1.csv"},
+		{filepath.Join("dir", "archive.tar.gz"), "2", filepath.Join("dir", "archive.tar_2.gz")},
+		{".bashrc", "1", ".bashrc_1"},
+		{"README", "abc", "README_abc"},
+	}
+
+	for _, tt := range tests {
+		if got := withNameTag(tt.input, tt.tag); got != tt.expected {
+			t.Errorf("withNameTag(%q, %q) = %q; want %q", tt.input, tt.tag, got, tt.expected)
+		}
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	for policy, name := range collisionPolicyNames {
+		got, err := ParseCollisionPolicy(name)
+		if err != nil || got != policy {
+			t.Errorf("ParseCollisionPolicy(%q) = %v, %v; want %v", name, got, err, policy)
+		}
+	}
+
+	if _, err := ParseCollisionPolicy("explode"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
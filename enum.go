@@ -0,0 +1,54 @@
+package trc
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The option types of PartitionConfig, such as LinkMode or CollisionPolicy, are ints with a name
+// for every value. They marshal to those names, so manifests and saved plans stay readable, and
+// the CLI flags accept the same names. The helpers below implement that once for all of them,
+// given the map of names of a type.
+
+// enumString returns the name of v, or typeName(v) if v has no name.
+func enumString[T ~int](names map[T]string, typeName string, v T) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return typeName + "(" + strconv.Itoa(int(v)) + ")"
+}
+
+// parseEnum returns the value with the given name, ignoring case. The error names the kind of
+// value and lists every name, in the order of the values.
+func parseEnum[T ~int](names map[T]string, kind string, name string) (T, error) {
+	values := make([]T, 0, len(names))
+	for value, valueName := range names {
+		if strings.EqualFold(name, valueName) {
+			return value, nil
+		}
+		values = append(values, value)
+	}
+
+	slices.Sort(values)
+	expected := make([]string, len(values))
+	for i, value := range values {
+		expected[i] = names[value]
+	}
+
+	last := len(expected) - 1
+	var zero T
+	return zero, fmt.Errorf("unknown %s %q (expected %s or %s)", kind, name, strings.Join(expected[:last], ", "), expected[last])
+}
+
+// unmarshalEnum decodes a name written by MarshalText into v.
+func unmarshalEnum[T any](v *T, text []byte, parse func(string) (T, error)) error {
+	value, err := parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	*v = value
+	return nil
+}
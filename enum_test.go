@@ -0,0 +1,33 @@
+package trc
+
+import "testing"
+
+func TestParseEnum(t *testing.T) {
+	tests := []struct {
+		name     string
+		parse    func(string) error
+		expected string
+	}{
+		{"CollisionPolicy", func(name string) error { _, err := ParseCollisionPolicy(name); return err }, `unknown collision policy "bogus" (expected fail, skip, suffix, hash or overwrite)`},
+		{"FileKind", func(name string) error { _, err := ParseFileKind(name); return err }, `unknown file kind "bogus" (expected any or regular)`},
+		{"LinkMode", func(name string) error { _, err := ParseLinkMode(name); return err }, `unknown link mode "bogus" (expected symlink, hardlink, reflink, copy or move)`},
+		{"InvalidNamePolicy", func(name string) error { _, err := ParseInvalidNamePolicy(name); return err }, `unknown invalid name policy "bogus" (expected fail, skip or sanitize)`},
+		{"NameProfile", func(name string) error { _, err := ParseNameProfile(name); return err }, `unknown name profile "bogus" (expected auto, posix, windows, fat or none)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.parse("bogus"); err == nil || err.Error() != tt.expected {
+				t.Errorf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if got := enumString(linkModeNames, "LinkMode", LinkMode(42)); got != "LinkMode(42)" {
+		t.Errorf("expected a value without a name to be printed as LinkMode(42), got %q", got)
+	}
+
+	if mode, err := ParseLinkMode("HardLink"); err != nil || mode != LinkHardlink {
+		t.Errorf("expected names to be parsed ignoring case, got %v, %v", mode, err)
+	}
+}
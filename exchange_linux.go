@@ -0,0 +1,23 @@
+package trc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchangeDirs swaps the directories at a and b with renameat2(RENAME_EXCHANGE), so both paths
+// exist at every moment. Kernels and file systems that cannot exchange report ErrUnsupported.
+func exchangeDirs(a, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EINVAL):
+		return fmt.Errorf("failed to exchange %s and %s: %w", a, b, errors.ErrUnsupported)
+	default:
+		return &os.LinkError{Op: "exchange", Old: a, New: b, Err: err}
+	}
+}
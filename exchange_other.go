@@ -0,0 +1,10 @@
+//go:build !linux
+
+package trc
+
+import "errors"
+
+// exchangeDirs is only implemented on Linux, elsewhere directories are swapped with two renames.
+func exchangeDirs(a, b string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,50 @@
+package trc
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// failureList collects the errors of the files a run skipped because ContinueOnError is set. A
+// nil list collects nothing, so every error stops the run as it happens.
+type failureList struct {
+	mu   sync.Mutex // Files fail in the walk and link workers at once
+	errs []error
+}
+
+func newFailureList(config PartitionConfig) *failureList {
+	if !config.ContinueOnError {
+		return nil
+	}
+	return &failureList{}
+}
+
+// skip records the error of a single file and returns nil, so the run goes on without it. A nil
+// list returns err unchanged.
+func (l *failureList) skip(err error) error {
+	if l == nil || err == nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errs = append(l.errs, err)
+	return nil
+}
+
+// err joins every error recorded so far, in a stable order.
+func (l *failureList) err() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sort.SliceStable(l.errs, func(i, j int) bool {
+		return l.errs[i].Error() < l.errs[j].Error()
+	})
+	return errors.Join(l.errs...)
+}
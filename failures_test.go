@@ -0,0 +1,177 @@
+package trc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// flattenErrors returns the errors joined in err, descending into nested joins.
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(err)...)
+	}
+	return errs
+}
+
+func TestContinueOnError(t *testing.T) {
+	tests := []struct {
+		name      string
+		stream    bool
+		linkError bool // The run also fails to create the link of late.txt
+		run       func(config PartitionConfig) error
+	}{
+		{"Make", false, false, MakePartitions},
+		{"Stream", true, false, MakePartitions},
+		{"Apply", false, true, func(config PartitionConfig) error {
+			plan, planErr := Plan(config)
+			if plan == nil {
+				return planErr
+			}
+
+			// Block a planned link once it is too late to resolve the collision
+			if err := os.WriteFile(filepath.Join(config.OutputDirs[0], "late.txt"), []byte("mine"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return errors.Join(planErr, plan.Apply())
+		}},
+		{"Sync", false, false, func(config PartitionConfig) error {
+			report, err := SyncPartitions(config)
+			if config.ContinueOnError && report == nil {
+				t.Errorf("expected a report along with the errors")
+			}
+			return err
+		}},
+	}
+
+	sourceDir := t.TempDir()
+	for _, path := range []string{"a/same.txt", "b/same.txt", "aux.txt", "blocked.txt", "late.txt", "ok.txt"} {
+		path = filepath.Join(sourceDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		for _, continueOnError := range []bool{false, true} {
+			name := tt.name
+			if continueOnError {
+				name += " continuing on error"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				outputDir := filepath.Join(t.TempDir(), "partition1")
+				if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+
+				// A regular file in the way of a link
+				if err := os.WriteFile(filepath.Join(outputDir, "blocked.txt"), []byte("mine"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				config := PartitionConfig{
+					SourceDir:       sourceDir,
+					OutputDirs:      []string{outputDir},
+					ByFile:          true,
+					NameProfile:     NameProfileWindows,
+					Stream:          tt.stream,
+					SpillDir:        t.TempDir(),
+					ContinueOnError: continueOnError,
+				}
+
+				err := tt.run(config)
+				if err == nil {
+					t.Fatal("expected the run to fail")
+				}
+
+				if !continueOnError {
+					// The first failure stops the run before anything is linked
+					if _, err := os.Lstat(filepath.Join(outputDir, "ok.txt")); !errors.Is(err, os.ErrNotExist) {
+						t.Errorf("expected ok.txt not to be linked, got %v", err)
+					}
+					return
+				}
+
+				counts := make(map[string]int)
+				for _, err := range flattenErrors(err) {
+					var (
+						invalid   *InvalidNameError
+						collision *CollisionError
+						link      *LinkError
+					)
+
+					switch {
+					case errors.As(err, &invalid):
+						counts["invalid"]++
+						if invalid.Path != filepath.Join(sourceDir, "aux.txt") || invalid.Partition != -1 {
+							t.Errorf("unexpected invalid name %+v", invalid)
+						}
+					case errors.As(err, &collision):
+						counts["collision"]++
+						if collision.Partition != 0 || !errors.Is(err, ErrCollision) {
+							t.Errorf("unexpected collision %+v", collision)
+						}
+					case errors.As(err, &link):
+						counts["link"]++
+						if link.Path != filepath.Join(outputDir, "late.txt") || link.Partition != 0 {
+							t.Errorf("unexpected link error %+v", link)
+						}
+					default:
+						t.Errorf("unexpected error %v", err)
+					}
+				}
+
+				expected := map[string]int{"invalid": 1, "collision": 2}
+				if tt.linkError {
+					expected["link"] = 1
+				}
+
+				for kind, n := range expected {
+					if counts[kind] != n {
+						t.Errorf("expected %d %s errors, got %d: %v", n, kind, counts[kind], err)
+					}
+				}
+
+				// Every other file is linked, while the files in the way are left alone
+				for _, name := range []string{"ok.txt", "same.txt"} {
+					if _, err := os.Readlink(filepath.Join(outputDir, name)); err != nil {
+						t.Errorf("expected %s to be linked: %v", name, err)
+					}
+				}
+
+				if data, err := os.ReadFile(filepath.Join(outputDir, "blocked.txt")); err != nil || string(data) != "mine" {
+					t.Errorf("expected the blocking file to be left alone, got %q, %v", data, err)
+				}
+			})
+		}
+	}
+}
+
+func TestContinueOnErrorRejectsAtomic(t *testing.T) {
+	sourceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "partition1")
+	config := PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true, Atomic: true, ContinueOnError: true}
+	if err := MakePartitions(config); err == nil {
+		t.Fatal("expected atomic runs to refuse continuing on error")
+	}
+
+	if _, err := os.Lstat(outputDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing to be created, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package trc
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// fileID identifies a file by its absolute path with every symlink resolved, as device and inode
+// numbers are not available on this platform.
+type fileID struct {
+	path string
+}
+
+func newFileID(path string, info fs.FileInfo) (fileID, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		realPath, err = filepath.Abs(realPath)
+	}
+
+	if err != nil {
+		return fileID{}, fmt.Errorf("failed to identify %s: %w", path, err)
+	}
+
+	return fileID{path: realPath}, nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package trc
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file by device and inode, whatever path it is reached through.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func newFileID(path string, info fs.FileInfo) (fileID, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fmt.Errorf("failed to identify %s: no device and inode", path)
+	}
+
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, nil
+}
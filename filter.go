@@ -0,0 +1,324 @@
+package trc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// FileKind selects which kinds of files are partitioned. Special files such as pipes, sockets
+// and devices never are.
+type FileKind int
+
+const (
+	FileKindAny     FileKind = iota // Regular files and symlinks (default)
+	FileKindRegular                 // Regular files only
+)
+
+var fileKindNames = map[FileKind]string{
+	FileKindAny:     "any",
+	FileKindRegular: "regular",
+}
+
+// String returns the name of the kind as accepted by ParseFileKind.
+func (k FileKind) String() string {
+	return enumString(fileKindNames, "FileKind", k)
+}
+
+func (k FileKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *FileKind) UnmarshalText(text []byte) error {
+	return unmarshalEnum(k, text, ParseFileKind)
+}
+
+// ParseFileKind converts a kind name (any, regular) into a FileKind.
+func ParseFileKind(name string) (FileKind, error) {
+	return parseEnum(fileKindNames, "file kind", name)
+}
+
+// fileFilter decides which files under the source directory are partitioned. Patterns are
+// doublestar globs matched against the path relative to the source directory, using forward
+// slashes, so "**/*.tmp" matches temporary files at any depth. Ignore files found while walking
+// the tree are applied on top of them.
+type fileFilter struct {
+	sourceDir string
+	include   []string
+	exclude   []string
+	ignore    *ignoreRules
+
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	kind           FileKind
+	followSymlinks bool
+	workers        int
+
+	names         nameRules
+	preserveTree  bool // Directory names are part of the link path and are checked too
+	invalidNames  InvalidNamePolicy
+	onInvalidName func(InvalidName)
+
+	log      *slog.Logger
+	progress *phaseProgress // Walk progress, set by the caller of the walk
+	failures *failureList   // Files the walk skipped with ContinueOnError, set by the caller of the walk
+}
+
+// newFileFilter validates the selection settings of the configuration.
+func newFileFilter(config PartitionConfig) (*fileFilter, error) {
+	if config.MinSize < 0 || config.MaxSize < 0 {
+		return nil, errors.New("file size limits cannot be negative")
+	}
+
+	if config.MaxSize > 0 && config.MinSize > config.MaxSize {
+		return nil, fmt.Errorf("minimum file size %d is larger than the maximum %d", config.MinSize, config.MaxSize)
+	}
+
+	if !config.ModifiedAfter.IsZero() && !config.ModifiedBefore.IsZero() && !config.ModifiedAfter.Before(config.ModifiedBefore) {
+		return nil, fmt.Errorf("modification window is empty: after %s and before %s", config.ModifiedAfter, config.ModifiedBefore)
+	}
+
+	if _, ok := fileKindNames[config.FileKind]; !ok {
+		return nil, fmt.Errorf("unknown file kind %s", config.FileKind)
+	}
+
+	if config.WalkWorkers < 0 {
+		return nil, errors.New("the number of walk workers cannot be negative")
+	}
+
+	if _, ok := nameProfileNames[config.NameProfile]; !ok {
+		return nil, fmt.Errorf("unknown name profile %s", config.NameProfile)
+	}
+
+	if _, ok := invalidNamePolicyNames[config.InvalidNamePolicy]; !ok {
+		return nil, fmt.Errorf("unknown invalid name policy %s", config.InvalidNamePolicy)
+	}
+
+	for _, patterns := range [][]string{config.Include, config.Exclude} {
+		for _, pattern := range patterns {
+			if !doublestar.ValidatePattern(pattern) {
+				return nil, fmt.Errorf("invalid glob pattern %q", pattern)
+			}
+		}
+	}
+
+	return &fileFilter{
+		sourceDir: config.SourceDir,
+		include:   config.Include,
+		exclude:   config.Exclude,
+		ignore:    newIgnoreRules(config.UseGitignore),
+
+		minSize:        config.MinSize,
+		maxSize:        config.MaxSize,
+		modifiedAfter:  config.ModifiedAfter,
+		modifiedBefore: config.ModifiedBefore,
+		kind:           config.FileKind,
+		followSymlinks: config.FollowSymlinks,
+		workers:        config.WalkWorkers,
+
+		names:         nameProfileRules[resolveNameProfile(config.NameProfile, config.OutputDirs)],
+		preserveTree:  config.PreserveTree,
+		invalidNames:  config.InvalidNamePolicy,
+		onInvalidName: config.OnInvalidName,
+
+		log: newLogger(config),
+	}, nil
+}
+
+// enterDir is called for every directory of the walk. It returns filepath.SkipDir if the
+// directory is excluded or ignored, and otherwise loads its ignore files. A nil filter enters
+// every directory and ignores nothing.
+func (f *fileFilter) enterDir(path string) error {
+	if f == nil {
+		return nil
+	}
+
+	relPath := f.relPath(path)
+	if path != f.sourceDir && (matchAny(f.exclude, relPath) || f.ignore.ignored(relPath, true)) {
+		return filepath.SkipDir
+	}
+
+	if path == f.sourceDir {
+		relPath = ""
+	}
+	return f.ignore.load(path, relPath)
+}
+
+// includes reports whether the file at path is selected: it must match one of the include
+// patterns, if there are any, and neither an exclude pattern nor an ignore file. The .trcignore
+// files themselves are never selected.
+func (f *fileFilter) includes(path string) bool {
+	if f == nil {
+		return true
+	}
+
+	relPath := f.relPath(path)
+	if len(f.include) > 0 && !matchAny(f.include, relPath) {
+		return false
+	}
+
+	return !matchAny(f.exclude, relPath) && !f.ignore.ignored(relPath, false) && filepath.Base(path) != TrcIgnoreFile
+}
+
+// selects reports whether a file selected by includes also passes the kind, size and
+// modification time limits. The limits apply to the target of a symlink when it can be read.
+func (f *fileFilter) selects(path string, info fs.FileInfo) bool {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		if f != nil && f.kind == FileKindRegular {
+			return false
+		}
+
+		if target, err := os.Stat(path); err == nil {
+			info = target
+		}
+	case !info.Mode().IsRegular():
+		return false
+	}
+
+	if f == nil {
+		return true
+	}
+
+	if info.Size() < f.minSize || (f.maxSize > 0 && info.Size() > f.maxSize) {
+		return false
+	}
+
+	modTime := info.ModTime()
+	if !f.modifiedAfter.IsZero() && !modTime.After(f.modifiedAfter) {
+		return false
+	}
+
+	return f.modifiedBefore.IsZero() || modTime.Before(f.modifiedBefore)
+}
+
+// followsSymlinks reports whether symlinks in the source tree are followed. A nil filter does not
+// follow them.
+func (f *fileFilter) followsSymlinks() bool {
+	return f != nil && f.followSymlinks
+}
+
+// walked reports a file handed to the caller of the walk.
+func (f *fileFilter) walked(file sourceFile) {
+	if f != nil {
+		f.progress.add(-1, file.info.Size())
+	}
+}
+
+// skip records the error of a single file the walk leaves out with ContinueOnError, and returns
+// it unless the walk goes on without the file.
+func (f *fileFilter) skip(err error) error {
+	if f == nil {
+		return err
+	}
+	return f.failures.skip(err)
+}
+
+// logger returns the logger of the walk, which discards everything for a nil filter.
+func (f *fileFilter) logger() *slog.Logger {
+	if f == nil {
+		return discardLogger
+	}
+	return f.log
+}
+
+// walkWorkers returns the number of directories read concurrently, GOMAXPROCS unless configured.
+func (f *fileFilter) walkWorkers() int {
+	if f == nil {
+		return workerCount(0)
+	}
+	return workerCount(f.workers)
+}
+
+// linkName checks the name of a selected file against the rules of the name profile, along with
+// the names of its directories inside the source tree when they are part of the link path. It
+// returns the name to link the file as, empty if it keeps its own, and whether the file is
+// partitioned at all. A returned name is the whole path relative to the partition, in slash form,
+// when directories are kept. Invalid names are handled with the configured InvalidNamePolicy; a
+// nil filter applies the FAT rules and fails on invalid names.
+func (f *fileFilter) linkName(path string) (string, bool, error) {
+	rules := nameProfileRules[NameProfileFAT]
+	names := []string{filepath.Base(path)}
+	if f != nil {
+		rules = f.names
+		if f.preserveTree {
+			names = strings.Split(f.relPath(path), "/")
+		}
+	}
+
+	var err error
+	for i, name := range names {
+		if err = rules.validate(name); err != nil {
+			if i < len(names)-1 {
+				err = fmt.Errorf("directory %s: %w", name, err)
+			}
+			break
+		}
+	}
+
+	if err == nil {
+		return "", true, nil
+	}
+
+	invalid := InvalidName{Path: path, Reason: err.Error()}
+	if f != nil {
+		invalid.Policy = f.invalidNames
+	}
+
+	switch invalid.Policy {
+	case InvalidNameSkip:
+		f.report(invalid)
+		f.logger().Warn("skipped file with invalid name", "path", path, "reason", invalid.Reason)
+		return "", false, nil
+
+	case InvalidNameSanitize:
+		for i, name := range names {
+			if rules.validate(name) != nil {
+				names[i] = rules.sanitize(name)
+			}
+		}
+
+		invalid.Sanitized = strings.Join(names, "/")
+		f.report(invalid)
+		f.logger().Warn("sanitized invalid file name", "path", path, "reason", invalid.Reason, "name", invalid.Sanitized)
+		return invalid.Sanitized, true, nil
+
+	default:
+		f.report(invalid)
+		return "", false, f.skip(&InvalidNameError{Path: path, Partition: -1, Err: err})
+	}
+}
+
+func (f *fileFilter) report(invalid InvalidName) {
+	if f != nil && f.onInvalidName != nil {
+		f.onInvalidName(invalid)
+	}
+}
+
+func (f *fileFilter) relPath(path string) string {
+	relPath, err := filepath.Rel(f.sourceDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// matchAny reports whether relPath matches one of the patterns. The patterns were validated by
+// newFileFilter, so matching cannot fail.
+func matchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := doublestar.Match(pattern, relPath); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package trc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileFilter(t *testing.T) {
+	files := []string{
+		"a.csv",
+		"b.tmp",
+		".DS_Store",
+		"nested/c.csv",
+		"nested/.DS_Store",
+		"nested/d.json",
+		"checkpoints/e.csv",
+		"nested/checkpoints/f.csv",
+	}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{
+			name:     "no patterns",
+			expected: files,
+		},
+		{
+			name:     "exclude",
+			exclude:  []string{"**/*.tmp", "**/.DS_Store", "**/checkpoints"},
+			expected: []string{"a.csv", "nested/c.csv", "nested/d.json"},
+		},
+		{
+			name:     "include",
+			include:  []string{"**/*.csv"},
+			expected: []string{"a.csv", "nested/c.csv", "checkpoints/e.csv", "nested/checkpoints/f.csv"},
+		},
+		{
+			name:     "exclude wins over include",
+			include:  []string{"**/*.csv"},
+			exclude:  []string{"checkpoints/**"},
+			expected: []string{"a.csv", "nested/c.csv", "nested/checkpoints/f.csv"},
+		},
+		{
+			name:     "patterns are relative to the source",
+			include:  []string{"*.csv"},
+			expected: []string{"a.csv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			for _, file := range files {
+				path := filepath.Join(tempDir, filepath.FromSlash(file))
+				if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+					t.Fatalf("error creating directory: %v", err)
+				}
+
+				if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+			}
+
+			filter, err := newFileFilter(PartitionConfig{SourceDir: tempDir, Include: tt.include, Exclude: tt.exclude})
+			if err != nil {
+				t.Fatalf("newFileFilter failed: %v", err)
+			}
+
+			collected, err := collectFilesWithSize(context.Background(), tempDir, filter)
+			if err != nil {
+				t.Fatalf("collectFilesWithSize failed: %v", err)
+			}
+
+			var got []string
+			for _, file := range collected {
+				relPath, _ := filepath.Rel(tempDir, file.path)
+				got = append(got, filepath.ToSlash(relPath))
+			}
+
+			expected := append([]string{}, tt.expected...)
+			sort.Strings(got)
+			sort.Strings(expected)
+
+			if len(got) != len(expected) {
+				t.Fatalf("expected %v, got %v", expected, got)
+			}
+
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Errorf("expected %v, got %v", expected, got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestFileFilterRejectsInvalidPatterns(t *testing.T) {
+	if _, err := newFileFilter(PartitionConfig{Exclude: []string{"[unclosed"}}); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
+
+func TestFileFilterMetadata(t *testing.T) {
+	now := time.Now()
+	files := []struct {
+		name    string
+		size    int
+		modTime time.Time
+	}{
+		{name: "small-old.txt", size: 10, modTime: now.Add(-30 * 24 * time.Hour)},
+		{name: "small-new.txt", size: 10, modTime: now.Add(-time.Hour)},
+		{name: "large-old.bin", size: 5000, modTime: now.Add(-30 * 24 * time.Hour)},
+		{name: "large-new.bin", size: 5000, modTime: now.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		config   PartitionConfig
+		expected []string
+	}{
+		{
+			name:     "everything",
+			expected: []string{"small-old.txt", "small-new.txt", "large-old.bin", "large-new.bin", "link.bin"},
+		},
+		{
+			name:     "minimum size",
+			config:   PartitionConfig{MinSize: 1000},
+			expected: []string{"large-old.bin", "large-new.bin", "link.bin"},
+		},
+		{
+			name:     "maximum size",
+			config:   PartitionConfig{MaxSize: 1000},
+			expected: []string{"small-old.txt", "small-new.txt"},
+		},
+		{
+			name:     "modified after",
+			config:   PartitionConfig{ModifiedAfter: now.Add(-7 * 24 * time.Hour)},
+			expected: []string{"small-new.txt", "large-new.bin", "link.bin"},
+		},
+		{
+			name:     "modified before",
+			config:   PartitionConfig{ModifiedBefore: now.Add(-7 * 24 * time.Hour)},
+			expected: []string{"small-old.txt", "large-old.bin"},
+		},
+		{
+			name:     "regular files only",
+			config:   PartitionConfig{MinSize: 1000, FileKind: FileKindRegular},
+			expected: []string{"large-old.bin", "large-new.bin"},
+		},
+	}
+
+	tempDir := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(tempDir, file.name)
+		if err := os.WriteFile(path, make([]byte, file.size), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+
+		if err := os.Chtimes(path, file.modTime, file.modTime); err != nil {
+			t.Fatalf("error setting modification time: %v", err)
+		}
+	}
+
+	// Size and time limits apply to the target of a symlink
+	if err := os.Symlink(filepath.Join(tempDir, "large-new.bin"), filepath.Join(tempDir, "link.bin")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.SourceDir = tempDir
+
+			filter, err := newFileFilter(config)
+			if err != nil {
+				t.Fatalf("newFileFilter failed: %v", err)
+			}
+
+			collected, err := collectFilesWithSize(context.Background(), tempDir, filter)
+			if err != nil {
+				t.Fatalf("collectFilesWithSize failed: %v", err)
+			}
+
+			var got []string
+			for _, file := range collected {
+				got = append(got, filepath.Base(file.path))
+			}
+
+			expected := append([]string{}, tt.expected...)
+			sort.Strings(got)
+			sort.Strings(expected)
+
+			if strings.Join(got, ",") != strings.Join(expected, ",") {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func TestFileFilterRejectsInvalidLimits(t *testing.T) {
+	now := time.Now()
+	for _, config := range []PartitionConfig{
+		{MinSize: -1},
+		{MinSize: 100, MaxSize: 10},
+		{ModifiedAfter: now, ModifiedBefore: now.Add(-time.Hour)},
+		{FileKind: FileKind(42)},
+	} {
+		if _, err := newFileFilter(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
@@ -2,6 +2,10 @@ module github.com/ezrantn/trc
 
 go 1.24.0
 
-require github.com/gabriel-vasile/mimetype v1.4.8
+require (
+	github.com/bmatcuk/doublestar/v4 v4.10.2
+	github.com/gabriel-vasile/mimetype v1.4.8
+	golang.org/x/sys v0.41.0
+)
 
 require golang.org/x/net v0.33.0 // indirect
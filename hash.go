@@ -0,0 +1,58 @@
+package trc
+
+import (
+	"hash/fnv"
+	"path/filepath"
+)
+
+// hashRing assigns files to partitions with rendezvous (highest random weight) hashing. Every
+// partition scores every file, and the file goes to the partition with the highest score. The
+// score only depends on the file's path relative to the source directory and on the partition
+// directory, so adding or removing an output directory only moves about 1/N of the files.
+type hashRing struct {
+	seeds []uint64 // one per partition, derived from the output directory as given
+}
+
+// newHashRing seeds each partition from its output directory as given, only cleaned and in slash
+// form. Resolving it to an absolute path would make the assignments depend on the working
+// directory and the machine, so the same relative paths give the same partitions anywhere.
+func newHashRing(outputDirs []string) *hashRing {
+	seeds := make([]uint64, len(outputDirs))
+	for i, dir := range outputDirs {
+		seeds[i] = mix64(hashString(filepath.ToSlash(filepath.Clean(dir))))
+	}
+
+	return &hashRing{seeds: seeds}
+}
+
+// partition returns the index of the partition a file belongs to, given its path relative to the
+// source directory in slash form.
+func (r *hashRing) partition(relPath string) int {
+	key := hashString(relPath)
+	best, bestScore := 0, uint64(0)
+	for i, seed := range r.seeds {
+		if score := mix64(key ^ seed); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	return best
+}
+
+// hashString returns the 64-bit FNV-1a hash of s.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix64 is the splitmix64 finalizer. FNV alone mixes its last bytes poorly, which would make
+// the scores of similar paths correlated.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,88 @@
+package trc
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashStrategyIsStable(t *testing.T) {
+	var files []FileMeta
+	for i := 0; i < 2000; i++ {
+		relPath := fmt.Sprintf("dir%d/file%d.txt", i%7, i)
+		files = append(files, FileMeta{Path: filepath.Join("source", filepath.FromSlash(relPath)), RelPath: relPath})
+	}
+
+	outputDirs := []string{"partition1", "partition2", "partition3", "partition4"}
+	assignments := hashAssignments(t, files, outputDirs)
+
+	// The same files in another order land in the same partitions
+	reversed := make([]FileMeta, len(files))
+	for i, file := range files {
+		reversed[len(files)-1-i] = file
+	}
+
+	for path, dir := range hashAssignments(t, reversed, outputDirs) {
+		if assignments[path] != dir {
+			t.Fatalf("assignment of %s depends on walk order: %s vs %s", path, assignments[path], dir)
+		}
+	}
+
+	// The working directory and the spelling of the output directories do not matter
+	t.Chdir(t.TempDir())
+	spelled := []string{"./partition1", "partition2/", "partition3", "other/../partition4"}
+	for path, dir := range hashAssignments(t, files, spelled) {
+		if filepath.Clean(dir) != assignments[path] {
+			t.Fatalf("assignment of %s depends on the working directory: %s vs %s", path, assignments[path], dir)
+		}
+	}
+
+	// Every partition gets a reasonable share
+	counts := make(map[string]int)
+	for _, dir := range assignments {
+		counts[dir]++
+	}
+
+	for _, dir := range outputDirs {
+		if counts[dir] < len(files)/len(outputDirs)*3/4 {
+			t.Errorf("partition %s is underfilled: %v", dir, counts)
+		}
+	}
+
+	// Adding a partition only moves files into the new partition, about 1/N of them
+	grown := append(append([]string{}, outputDirs...), "partition5")
+	moved := 0
+	for path, dir := range hashAssignments(t, files, grown) {
+		if dir == assignments[path] {
+			continue
+		}
+
+		if dir != "partition5" {
+			t.Fatalf("%s moved from %s to %s instead of the new partition", path, assignments[path], dir)
+		}
+		moved++
+	}
+
+	if moved < len(files)/10 || moved > len(files)*3/10 {
+		t.Errorf("expected about 1/5 of the files to move, %d of %d moved", moved, len(files))
+	}
+}
+
+func hashAssignments(t *testing.T, files []FileMeta, outputDirs []string) map[string]string {
+	t.Helper()
+
+	result, err := hashStrategy{}.Assign(files, outputDirs)
+	if err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+
+	assignments := make(map[string]string)
+	for _, assignment := range result {
+		assignments[assignment.File.Path] = outputDirs[assignment.Partition]
+	}
+
+	if len(assignments) != len(files) {
+		t.Fatalf("expected %d assigned files, got %d", len(files), len(assignments))
+	}
+	return assignments
+}
@@ -0,0 +1,166 @@
+package trc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	TrcIgnoreFile = ".trcignore" // Ignore file read in every directory of the source tree
+	GitIgnoreFile = ".gitignore" // Ignore file read as well when PartitionConfig.UseGitignore is set
+)
+
+// vcsDirs are version control metadata directories, which are never partitioned.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, ".bzr": true}
+
+// isVCSDir reports whether path is a version control metadata directory below sourceDir.
+func isVCSDir(sourceDir, path string, isDir bool) bool {
+	return isDir && path != sourceDir && vcsDirs[filepath.Base(path)]
+}
+
+// ignoreRule is a single pattern of an ignore file, translated to a doublestar glob relative to
+// the directory holding the ignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool // The pattern started with "!" and re-includes what earlier rules ignored
+	dirOnly bool // The pattern ended with "/" and only matches directories
+}
+
+// ignoreRules holds the rules of the ignore files found so far, by directory relative to the
+// source directory ("" for the source directory itself).
+type ignoreRules struct {
+	files []string
+
+	mu    sync.RWMutex // The walk workers load and match rules concurrently
+	byDir map[string][]ignoreRule
+}
+
+func newIgnoreRules(useGitignore bool) *ignoreRules {
+	files := []string{TrcIgnoreFile}
+	if useGitignore {
+		files = append(files, GitIgnoreFile)
+	}
+
+	return &ignoreRules{files: files, byDir: make(map[string][]ignoreRule)}
+}
+
+// load reads the ignore files of a directory, given both on disk and relative to the source
+// directory in slash form.
+func (r *ignoreRules) load(dir, relDir string) error {
+	for _, name := range r.files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read ignore file: %w", err)
+		}
+
+		rules := parseIgnoreRules(data)
+		r.mu.Lock()
+		r.byDir[relDir] = append(r.byDir[relDir], rules...)
+		r.mu.Unlock()
+	}
+
+	return nil
+}
+
+// ignored reports whether the path, relative to the source directory in slash form, is ignored.
+// Rules of deeper directories take precedence, and within a directory the last matching rule
+// wins, so a later "!pattern" re-includes what an earlier pattern ignored.
+func (r *ignoreRules) ignored(relPath string, isDir bool) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Most trees have no ignore file at all
+	if len(r.byDir) == 0 {
+		return false
+	}
+
+	ignored := false
+	dir, rest := "", relPath
+	for start := 0; ; {
+		for _, rule := range r.byDir[dir] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+
+			if ok, _ := doublestar.Match(rule.pattern, rest); ok {
+				ignored = !rule.negate
+			}
+		}
+
+		i := strings.IndexByte(relPath[start:], '/')
+		if i < 0 {
+			return ignored
+		}
+
+		// The directories are prefixes of relPath, sliced rather than joined
+		start += i + 1
+		dir, rest = relPath[:start-1], relPath[start:]
+	}
+}
+
+// parseIgnoreRules parses the content of an ignore file written in gitignore syntax.
+func parseIgnoreRules(data []byte) []ignoreRule {
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		// Trailing spaces are ignored unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		// A slash anywhere but at the end anchors the pattern to the directory of the ignore
+		// file, otherwise it matches at any depth
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+
+		// Braces are plain characters in gitignore syntax
+		line = strings.NewReplacer("{", `\{`, "}", `\}`).Replace(line)
+		if !doublestar.ValidatePattern(line) {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
@@ -6,12 +6,12 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ezrantn/trc"
 )
 
 var (
-	version   = "v0.0.1"
 	asciiText = `
 ╱╭╮╱╱╱╱╱╱╱╱╱╱╱╱╱╱╭╮
 ╭╯╰╮╱╱╱╱╱╱╱╱╱╱╱╱╭╯╰╮
@@ -21,13 +21,41 @@ var (
 ╱╰━┻╯╰━━┻━━┻━━┻━━┻━╯`
 )
 
-// ParseCLI parses command-line arguments and returns a PartitionConfig.
-func ParseCLI() (trc.PartitionConfig, bool, error) {
+// Options holds everything parsed from the command line.
+type Options struct {
+	Config   trc.PartitionConfig // Partitioning configuration
+	Unlink   bool                // Remove partitions instead of creating them
+	Verify   bool                // Check existing partitions against their manifests
+	DryRun   bool                // Print the plan instead of creating links
+	Sync     bool                // Update existing partitions instead of recreating them
+	SavePlan string              // Write the plan to this file (JSON, or CSV for .csv names)
+	PlanFile string              // Apply a previously saved plan instead of planning
+	LinkMode *trc.LinkMode       // Link mode overriding the one saved in the plan file
+}
+
+// stringList is a flag that can be repeated, collecting every value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// ParseCLI parses command-line arguments and returns the Options to run with.
+func ParseCLI() (Options, error) {
 	if len(os.Args) == 1 {
 		printHelp()
 		os.Exit(0)
 	}
 
+	if os.Args[1] == "apply" {
+		return parseApply(os.Args[2:])
+	}
+
 	var versionFlag bool
 	flag.BoolVar(&versionFlag, "version", false, "Print trc version")
 	flag.BoolVar(&versionFlag, "v", false, "Shorthand for --version")
@@ -41,58 +69,274 @@ func ParseCLI() (trc.PartitionConfig, bool, error) {
 	bySize := flag.Bool("by-size", false, "Partition files by size")
 	flag.BoolVar(bySize, "b", false, "Shorthand for --by-size")
 
-	byFile := flag.Bool("by-type", false, "Partition by type")
-	flag.BoolVar(byFile, "t", false, "Shorthand for --by-type")
+	byType := flag.Bool("by-type", false, "Partition files by MIME type (the default)")
+	flag.BoolVar(byType, "t", false, "Shorthand for --by-type")
+
+	byHash := flag.Bool("by-hash", false, "Partition by consistent hashing of the relative paths")
+
+	strategy := flag.String("strategy", "", "Name of the partitioning strategy: "+strings.Join(trc.Strategies(), ", "))
+
+	mode := flag.String("mode", "symlink", "How files are placed in partitions: symlink, hardlink, reflink, copy or move")
+	flag.StringVar(mode, "m", "symlink", "Shorthand for --mode")
+
+	relativeLinks := flag.Bool("relative-links", false, "Point symlinks at their source relative to the link, so source and partitions can be moved together")
+
+	var include, exclude stringList
+	flag.Var(&include, "include", "Only partition files whose path relative to the source matches this glob (repeatable)")
+	flag.Var(&exclude, "exclude", "Leave out files and directories whose path relative to the source matches this glob (repeatable)")
+
+	minSize := flag.String("min-size", "", "Only partition files of at least this size, e.g. 10MB")
+	maxSize := flag.String("max-size", "", "Only partition files of at most this size, e.g. 1GiB")
+	newerThan := flag.String("newer-than", "", "Only partition files modified within this age (e.g. 7d, 36h) or after this date")
+	olderThan := flag.String("older-than", "", "Only partition files modified before this age (e.g. 30d) or date")
+	kind := flag.String("kind", "any", "Kind of files to partition: any (regular files and symlinks) or regular")
+
+	useGitignore := flag.Bool("gitignore", false, "Honor .gitignore files in the source tree as well as .trcignore files")
+	walkWorkers := flag.Int("walk-workers", 0, "Directories read concurrently while walking the source tree (default GOMAXPROCS)")
+	linkWorkers := flag.Int("link-workers", 0, "Links created concurrently (default GOMAXPROCS)")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Walk symlinked directories of the source tree and link files to their real paths")
+
+	preserveTree := flag.Bool("preserve-tree", false, "Recreate the source directory hierarchy inside each partition")
+	flag.BoolVar(preserveTree, "p", false, "Shorthand for --preserve-tree")
+
+	onCollision := flag.String("on-collision", "fail", "What to do when two files map to the same link: fail, skip, suffix, hash or overwrite")
+	flag.StringVar(onCollision, "c", "fail", "Shorthand for --on-collision")
+	profile := flag.String("name-profile", "auto", "Rules link names must follow: auto, posix, windows, fat or none")
+	invalidNames := flag.String("invalid-names", "fail", "What to do with files whose name is not a valid link name: fail, skip or sanitize")
 
 	unlink := flag.Bool("unlink", false, "Unlink symlinks and remove the partition directories")
 	flag.BoolVar(unlink, "u", false, "Shorthand for --unlink")
 
+	verify := flag.Bool("verify", false, "Check that the files in the partitions still match their manifests")
+
+	force := flag.Bool("force", false, "Let --unlink remove symlinks from directories without a trc manifest")
+	flag.BoolVar(force, "f", false, "Shorthand for --force")
+
+	dryRun := flag.Bool("dry-run", false, "Print the planned partitions without creating any links")
+	flag.BoolVar(dryRun, "n", false, "Shorthand for --dry-run")
+
+	sync := flag.Bool("sync", false, "Add new files to existing partitions and remove links to deleted files")
+
+	savePlan := flag.String("save-plan", "", "Write the plan to a file (JSON, or CSV if the name ends in .csv)")
+
+	stream := flag.Bool("stream", false, "Link files while walking the source tree instead of planning first (count, hash and size strategies)")
+	atomic := flag.Bool("atomic", false, "Build the partitions in staging directories and swap them into place once all of them succeeded")
+	continueOnError := flag.Bool("continue-on-error", false, "Skip the files that fail and report all of them at the end instead of stopping at the first")
+	spillDir := flag.String("spill-dir", "", "Directory for the temporary files of large runs (default the system temporary directory)")
+
+	logLevel := flag.String("log-level", "warn", "Lowest level of the messages logged to stderr: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Format of the messages logged to stderr: text or json")
+
 	flag.Parse()
 
 	if versionFlag {
-		fmt.Println(version)
+		fmt.Println(trc.Version)
 		os.Exit(0)
 	}
 
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		return Options{}, err
+	}
+
 	// Unlink mode (removing partitions)
 	if *unlink {
 		if *outputDirs == "" {
-			return trc.PartitionConfig{}, false, errors.New("missing required --output flag for unlink mode")
+			return Options{}, errors.New("missing required --output flag for unlink mode")
+		}
+
+		outputDirsList, err := splitOutputDirs(*outputDirs)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid output directories: %w", err)
+		}
+
+		config := trc.PartitionConfig{
+			SourceDir:       *sourceDir,
+			OutputDirs:      outputDirsList,
+			Force:           *force,
+			ContinueOnError: *continueOnError,
+			Logger:          logger,
+		}
+
+		return Options{Config: config, Unlink: true}, nil
+	}
+
+	// Verify mode (checking partitions)
+	if *verify {
+		if *outputDirs == "" {
+			return Options{}, errors.New("missing required --output flag for verify mode")
 		}
 
 		outputDirsList, err := splitOutputDirs(*outputDirs)
 		if err != nil {
-			return trc.PartitionConfig{}, false, fmt.Errorf("invalid output directories: %w", err)
+			return Options{}, fmt.Errorf("invalid output directories: %w", err)
 		}
 
-		return trc.PartitionConfig{OutputDirs: outputDirsList}, true, nil
+		return Options{Config: trc.PartitionConfig{OutputDirs: outputDirsList, Logger: logger}, Verify: true}, nil
 	}
 
 	// Regular partitioning mode
 	if *sourceDir == "" {
-		return trc.PartitionConfig{}, false, errors.New("missing required --source flag")
+		return Options{}, errors.New("missing required --source flag")
 	}
 
 	if *outputDirs == "" {
-		return trc.PartitionConfig{}, false, errors.New("missing required --output flag")
+		return Options{}, errors.New("missing required --output flag")
 	}
 
 	outputDirsList, err := splitOutputDirs(*outputDirs)
 	if err != nil {
-		return trc.PartitionConfig{}, false, fmt.Errorf("invalid output directories: %w", err)
+		return Options{}, fmt.Errorf("invalid output directories: %w", err)
+	}
+
+	collisionPolicy, err := trc.ParseCollisionPolicy(*onCollision)
+	if err != nil {
+		return Options{}, err
+	}
+
+	linkMode, err := trc.ParseLinkMode(*mode)
+	if err != nil {
+		return Options{}, err
+	}
+
+	fileKind, err := trc.ParseFileKind(*kind)
+	if err != nil {
+		return Options{}, err
+	}
+
+	nameProfile, err := trc.ParseNameProfile(*profile)
+	if err != nil {
+		return Options{}, err
+	}
+
+	invalidNamePolicy, err := trc.ParseInvalidNamePolicy(*invalidNames)
+	if err != nil {
+		return Options{}, err
+	}
+
+	// --by-type selects the mime strategy by name, so it conflicts with the other strategies
+	strategyName := *strategy
+	if *byType {
+		if strategyName != "" && strategyName != "mime" {
+			return Options{}, fmt.Errorf("--by-type cannot be combined with --strategy %s", strategyName)
+		}
+		strategyName = "mime"
+	}
+
+	config := trc.PartitionConfig{
+		SourceDir:       *sourceDir,
+		OutputDirs:      outputDirsList,
+		Strategy:        strategyName,
+		BySize:          *bySize,
+		ByHash:          *byHash,
+		PreserveTree:    *preserveTree,
+		LinkMode:        linkMode,
+		RelativeLinks:   *relativeLinks,
+		Include:         include,
+		Exclude:         exclude,
+		UseGitignore:    *useGitignore,
+		FollowSymlinks:  *followSymlinks,
+		WalkWorkers:     *walkWorkers,
+		LinkWorkers:     *linkWorkers,
+		FileKind:        fileKind,
+		Stream:          *stream,
+		Atomic:          *atomic,
+		SpillDir:        *spillDir,
+		ContinueOnError: *continueOnError,
+
+		CollisionPolicy:   collisionPolicy,
+		NameProfile:       nameProfile,
+		InvalidNamePolicy: invalidNamePolicy,
+
+		OnProgress: newProgressPrinter(),
+		Logger:     logger,
+	}
+
+	if err := parseSelection(&config, *minSize, *maxSize, *newerThan, *olderThan); err != nil {
+		return Options{}, err
+	}
+
+	if *sync && (*dryRun || *savePlan != "") {
+		return Options{}, errors.New("--sync cannot be combined with --dry-run or --save-plan")
+	}
+
+	if *stream && (*dryRun || *savePlan != "" || *sync) {
+		return Options{}, errors.New("--stream cannot be combined with --dry-run, --save-plan or --sync")
+	}
+
+	if *atomic && (*stream || *sync || *continueOnError) {
+		return Options{}, errors.New("--atomic cannot be combined with --stream, --sync or --continue-on-error")
+	}
+
+	return Options{Config: config, DryRun: *dryRun, Sync: *sync, SavePlan: *savePlan}, nil
+}
+
+// parseSelection sets the size and modification time limits given on the command line.
+func parseSelection(config *trc.PartitionConfig, minSize, maxSize, newerThan, olderThan string) error {
+	var err error
+	if minSize != "" {
+		if config.MinSize, err = parseSize(minSize); err != nil {
+			return fmt.Errorf("--min-size: %w", err)
+		}
+	}
+
+	if maxSize != "" {
+		if config.MaxSize, err = parseSize(maxSize); err != nil {
+			return fmt.Errorf("--max-size: %w", err)
+		}
+	}
+
+	now := time.Now()
+	if newerThan != "" {
+		if config.ModifiedAfter, err = parseAge(newerThan, now); err != nil {
+			return fmt.Errorf("--newer-than: %w", err)
+		}
+	}
+
+	if olderThan != "" {
+		if config.ModifiedBefore, err = parseAge(olderThan, now); err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseApply parses the arguments of `trc apply <plan>`.
+func parseApply(args []string) (Options, error) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	mode := fs.String("mode", "", "Link mode to use instead of the one saved in the plan")
+	atomic := fs.Bool("atomic", false, "Build the partitions in staging directories and swap them into place once all of them succeeded")
+	continueOnError := fs.Bool("continue-on-error", false, "Create every link that can be created and report all the failures at the end")
+	logLevel := fs.String("log-level", "warn", "Lowest level of the messages logged to stderr: debug, info, warn or error")
+	logFormat := fs.String("log-format", "text", "Format of the messages logged to stderr: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return Options{}, errors.New("usage: trc apply [--mode <mode>] [--atomic] [--continue-on-error] [--log-level <level>] [--log-format <format>] <plan.json|plan.csv>")
+	}
+
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		return Options{}, err
+	}
+
+	opts := Options{PlanFile: fs.Arg(0), Config: trc.PartitionConfig{Atomic: *atomic, ContinueOnError: *continueOnError, OnProgress: newProgressPrinter(), Logger: logger}}
+	if *mode != "" {
+		linkMode, err := trc.ParseLinkMode(*mode)
+		if err != nil {
+			return Options{}, err
+		}
+		opts.LinkMode = &linkMode
 	}
 
-	return trc.PartitionConfig{
-		SourceDir:  *sourceDir,
-		OutputDirs: outputDirsList,
-		BySize:     *bySize,
-		ByFile:     *byFile,
-	}, false, nil
+	return opts, nil
 }
 
 // printError prints an error in red color
 func PrintError(err error) {
-	fmt.Fprintf(os.Stderr, "%sERROR:%s %v\n", trc.Red, trc.Reset, err)
+	stderr.endBar()
+	fmt.Fprintf(stderr, "%sERROR:%s %v\n", trc.Red, trc.Reset, err)
 }
 
 // splitOutputDirs splits output directories from a comma-separated string.
@@ -124,20 +368,64 @@ func printHelp() {
 	fmt.Println("  - Save disk space by using symlinks instead of copying files.")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  trc --source <dir> --output <dir1,dir2,...> [--by-size]")
-	fmt.Println("  trc --unlink --output <dir1,dir2,...>")
+	fmt.Println("  trc --source <dir> --output <dir1,dir2,...> [--by-size] [--preserve-tree]")
+	fmt.Println("  trc --unlink --output <dir1,dir2,...> [--force]")
+	fmt.Println("  trc --verify --output <dir1,dir2,...>")
+	fmt.Println("  trc apply [--mode <mode>] [--atomic] [--continue-on-error] [--log-level <level>] [--log-format <format>] <plan.json|plan.csv>")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  -s, --source <dir>   Source directory to partition")
 	fmt.Println("  -o, --output <dirs>  Comma-separated list of output directories")
 	fmt.Println("  -b, --by-size        Partition files by size instead of default method")
-	fmt.Println("  -t, --by-type        Partition files by MIME type")
-	fmt.Println("  -u, --unlink         Remove symlinks and partition directories")
+	fmt.Println("  -t, --by-type        Partition files by MIME type (default)")
+	fmt.Println("      --by-hash        Partition by consistent hashing, so assignments stay stable as files and partitions are added")
+	fmt.Println("      --strategy <s>   Partition with a named strategy: " + strings.Join(trc.Strategies(), ", "))
+	fmt.Println("  -m, --mode <mode>    Place files as symlink (default), hardlink, reflink, copy or move")
+	fmt.Println("      --relative-links Use symlink targets relative to each link instead of absolute paths")
+	fmt.Println("      --include <glob> Only partition matching files, e.g. '**/*.csv' (repeatable)")
+	fmt.Println("      --exclude <glob> Leave out matching files and directories, e.g. '**/*.tmp' (repeatable)")
+	fmt.Println("      --min-size <n>   Only partition files of at least this size, e.g. 10MB or 1.5GiB")
+	fmt.Println("      --max-size <n>   Only partition files of at most this size")
+	fmt.Println("      --newer-than <t> Only partition files modified within an age (7d, 2w, 36h) or after a date (2024-01-31)")
+	fmt.Println("      --older-than <t> Only partition files modified before an age or date")
+	fmt.Println("      --kind <k>       Partition regular files and symlinks (any, default) or regular files only (regular)")
+	fmt.Println("      --gitignore      Honor .gitignore files as well as .trcignore files")
+	fmt.Println("      --follow-symlinks Walk symlinked directories in the source tree and link files to their real paths")
+	fmt.Println("      --walk-workers <n> Read up to n directories of the source tree at once (default GOMAXPROCS)")
+	fmt.Println("      --link-workers <n> Create up to n links at once (default GOMAXPROCS)")
+	fmt.Println("  -p, --preserve-tree  Keep each file's path relative to the source inside its partition")
+	fmt.Println("  -c, --on-collision   Handle duplicate link names: fail (default), skip, suffix, hash, overwrite")
+	fmt.Println("      --name-profile   Rules link names must follow: auto (default, from the output file system), posix, windows, fat, none")
+	fmt.Println("      --invalid-names  Handle file names that break those rules: fail (default), skip, sanitize")
+	fmt.Println("  -u, --unlink         Remove the links trc created and prune empty partition directories")
+	fmt.Println("      --verify         Check that the files in the partitions still match their manifests")
+	fmt.Println("  -f, --force          With --unlink, also clean directories that have no trc manifest")
+	fmt.Println("  -n, --dry-run        Print the planned partitions and their totals without creating links")
+	fmt.Println("      --save-plan <f>  Write the plan to a JSON file, or CSV if the name ends in .csv")
+	fmt.Println("      --sync           Update existing partitions: link new files, remove links to deleted ones")
+	fmt.Println("      --stream         Link files while walking, without planning first; for count, hash and size")
+	fmt.Println("      --atomic         Build partitions in hidden staging directories and swap them in once all succeeded")
+	fmt.Println("      --continue-on-error Skip files that fail and report all of them at the end")
+	fmt.Println("      --spill-dir <d>  Write the temporary files of large runs to d instead of the system temporary directory")
+	fmt.Println("      --log-level <l>  Log debug, info, warn (default) or error messages and above to stderr")
+	fmt.Println("      --log-format <f> Log as text (default) or json")
 	fmt.Println("  -v, --version        Print trc (treecut) version")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  trc --source /data --output /part1,/part2")
 	fmt.Println("  trc -s /data -o /part1,/part2")
+	fmt.Println("  trc --source /data --output /part1,/part2 --preserve-tree")
+	fmt.Println("  trc --source /data --output /part1,/part2 --strategy hash")
+	fmt.Println("  trc --source /data --output /part1,/part2 --exclude '**/*.tmp' --exclude '**/.DS_Store'")
+	fmt.Println("  trc --source /data --output /part1,/part2 --min-size 10MB --newer-than 7d")
+	fmt.Println("  trc --source /data --output /part1,/part2 --dry-run")
+	fmt.Println("  trc --source /data --output /part1,/part2 --dry-run --save-plan plan.json")
+	fmt.Println("  trc apply plan.json")
+	fmt.Println("  trc --source /data --output /part1,/part2 --sync")
+	fmt.Println("  trc --source /data --output /part1,/part2 --mode hardlink")
+	fmt.Println("  trc --source /data --output /part1,/part2 --by-hash --stream")
+	fmt.Println("  trc --source /data --output /part1,/part2 --log-level debug --log-format json")
+	fmt.Println("  trc --verify --output /part1,/part2")
 	fmt.Println("  trc --unlink --output /part1,/part2")
 	fmt.Println("  trc -u -o /part1,/part2")
 	fmt.Println()
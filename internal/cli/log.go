@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// stderr is where the CLI writes its logs, progress and errors.
+var stderr = &statusWriter{w: os.Stderr}
+
+// statusWriter serializes everything the CLI writes to stderr, so log lines do not end up in the
+// middle of the progress bar: the bar is cleared before a log line and drawn again after it.
+type statusWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	bar string // Progress bar currently drawn, empty if there is none
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bar != "" {
+		fmt.Fprint(s.w, "\r\033[K")
+	}
+
+	n, err := s.w.Write(p)
+	if s.bar != "" {
+		fmt.Fprint(s.w, s.bar)
+	}
+	return n, err
+}
+
+// drawBar draws bar in place of the current one. A bar that is done stays on its own line.
+func (s *statusWriter) drawBar(bar string, done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(s.w, "\r\033[K%s", bar)
+	s.bar = bar
+	if done {
+		fmt.Fprintln(s.w)
+		s.bar = ""
+	}
+}
+
+// endBar leaves the current progress bar on its own line, so what follows is not drawn over it.
+func (s *statusWriter) endBar() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bar != "" {
+		fmt.Fprintln(s.w)
+		s.bar = ""
+	}
+}
+
+// newLogger returns the logger of the CLI, which writes to stderr at the given level (debug,
+// info, warn or error) in the given format (text or json).
+func newLogger(level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", level)
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(stderr, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ezrantn/trc"
+)
+
+// PrintPlan writes the assignment of files to partitions followed by the totals of each partition.
+func PrintPlan(w io.Writer, plan *trc.PartitionPlan) {
+	for i, partition := range plan.Partitions {
+		fmt.Fprintf(w, "Partition %d: %s\n", i+1, partition.Dir)
+		for _, link := range partition.Links {
+			fmt.Fprintf(w, "  %s -> %s\n", partition.LinkPath(link), link.Source)
+		}
+	}
+
+	if len(plan.Collisions) > 0 {
+		fmt.Fprintf(w, "\n%d collision(s) resolved with policy %q\n", len(plan.Collisions), plan.Config.CollisionPolicy)
+	}
+
+	if len(plan.InvalidNames) > 0 {
+		fmt.Fprintf(w, "\n%d invalid file name(s) handled with policy %q\n", len(plan.InvalidNames), plan.Config.InvalidNamePolicy)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Plan (%s strategy):\n", plan.Strategy)
+	for i, partition := range plan.Partitions {
+		fmt.Fprintf(w, "  Partition %d: %d files, %s  %s\n", i+1, len(partition.Links), formatBytes(partition.TotalSize()), partition.Dir)
+	}
+	fmt.Fprintf(w, "  Total: %d files\n", plan.TotalFiles())
+}
+
+// formatBytes renders a byte count using binary units, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ezrantn/trc"
+)
+
+const (
+	barRedraw   = 100 * time.Millisecond // Shortest time between two redraws of the progress bar
+	logInterval = 5 * time.Second        // Time between two progress lines when stderr is not a terminal
+	barWidth    = 24
+)
+
+// progressPrinter shows the progress of a run on stderr: a progress bar redrawn in place when
+// stderr is a terminal, and a line every few seconds when it is redirected to a file or a pipe.
+type progressPrinter struct {
+	w       *statusWriter
+	tty     bool
+	started map[trc.Phase]time.Time
+	logged  map[trc.Phase]time.Time // Last line printed for each phase, its start until then
+	last    time.Time               // Last redraw of the progress bar
+}
+
+// newProgressPrinter returns the OnProgress callback of the CLI.
+func newProgressPrinter() func(trc.Progress) {
+	p := &progressPrinter{
+		w:       stderr,
+		tty:     isTerminal(os.Stderr),
+		started: make(map[trc.Phase]time.Time),
+		logged:  make(map[trc.Phase]time.Time),
+	}
+	return p.print
+}
+
+// isTerminal reports whether f is a character device, which is how a terminal shows up.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressPrinter) print(event trc.Progress) {
+	now := time.Now()
+	if event.Files == 0 && !event.Done {
+		p.started[event.Phase] = now
+		p.logged[event.Phase] = now
+	}
+
+	if p.tty {
+		if !event.Done && now.Sub(p.last) < barRedraw {
+			return
+		}
+		p.last = now
+		p.w.drawBar(p.bar(event, now), event.Done)
+		return
+	}
+
+	switch {
+	case event.Done:
+		// Phases too short for a periodic line are not logged at all
+		if p.logged[event.Phase].Equal(p.started[event.Phase]) {
+			return
+		}
+	case now.Sub(p.logged[event.Phase]) < logInterval:
+		return
+	}
+
+	p.logged[event.Phase] = now
+	fmt.Fprintf(p.w, "trc: %s\n", p.line(event, now))
+}
+
+// bar renders an event as a progress bar, or as a counter when the total is not known.
+func (p *progressPrinter) bar(event trc.Progress, now time.Time) string {
+	if event.TotalFiles == 0 {
+		return fmt.Sprintf("%-6s %s", event.Phase, p.stats(event, now))
+	}
+
+	done := fraction(event)
+	filled := int(done * barWidth)
+	return fmt.Sprintf("%-6s [%s%s] %3.0f%% %s", event.Phase, strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), done*100, p.stats(event, now))
+}
+
+// line renders an event as a log line.
+func (p *progressPrinter) line(event trc.Progress, now time.Time) string {
+	if event.TotalFiles == 0 {
+		return fmt.Sprintf("%s: %s", event.Phase, p.stats(event, now))
+	}
+	return fmt.Sprintf("%s: %.0f%%, %s", event.Phase, fraction(event)*100, p.stats(event, now))
+}
+
+// stats renders the counts, throughput, ETA and partition of an event.
+func (p *progressPrinter) stats(event trc.Progress, now time.Time) string {
+	var b strings.Builder
+	if event.TotalFiles > 0 {
+		fmt.Fprintf(&b, "%d/%d files, %s/%s", event.Files, event.TotalFiles, formatBytes(event.Bytes), formatBytes(event.TotalBytes))
+	} else {
+		fmt.Fprintf(&b, "%d files, %s", event.Files, formatBytes(event.Bytes))
+	}
+
+	elapsed := now.Sub(p.started[event.Phase]).Seconds()
+	if elapsed <= 0 || event.Files == 0 {
+		return b.String()
+	}
+	fmt.Fprintf(&b, ", %.0f files/s, %s/s", float64(event.Files)/elapsed, formatBytes(int64(float64(event.Bytes)/elapsed)))
+
+	if done := fraction(event); !event.Done && done > 0 && done < 1 {
+		eta := time.Duration(elapsed * (1 - done) / done * float64(time.Second))
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+
+	if event.Partition >= 0 {
+		fmt.Fprintf(&b, ", partition %d", event.Partition+1)
+	}
+	return b.String()
+}
+
+// fraction returns how much of a phase is done, measured in bytes when the files have any.
+func fraction(event trc.Progress) float64 {
+	switch {
+	case event.TotalBytes > 0:
+		return min(float64(event.Bytes)/float64(event.TotalBytes), 1)
+	case event.TotalFiles > 0:
+		return min(float64(event.Files)/float64(event.TotalFiles), 1)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Multipliers of the size units accepted by parseSize. KB, MB, ... are decimal and KiB, MiB,
+// ... binary, like most storage tools.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1000,
+	"KB":  1000,
+	"KIB": 1 << 10,
+	"M":   1000 * 1000,
+	"MB":  1000 * 1000,
+	"MIB": 1 << 20,
+	"G":   1000 * 1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"GIB": 1 << 30,
+	"T":   1000 * 1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"TIB": 1 << 40,
+}
+
+// parseSize parses a size such as 512, 10MB or 1.5GiB into bytes.
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value[:i], 64)
+	multiplier, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(value[i:]))]
+	if err != nil || !ok || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 512, 10MB or 1.5GiB)", value)
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63, which no longer fits
+	size := number * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q (at most %d bytes)", value, int64(math.MaxInt64))
+	}
+
+	return int64(size), nil
+}
+
+// Multipliers of the day and week suffixes accepted by parseAge on top of time.ParseDuration.
+var ageUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseAge parses either an age such as 7d, 2w or 36h, which is subtracted from now, or a date
+// such as 2024-01-31 or an RFC 3339 timestamp.
+func parseAge(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	if unit, ok := ageUnits[value[max(len(value)-1, 0):]]; ok {
+		if n, err := strconv.ParseFloat(value[:len(value)-1], 64); err == nil && n >= 0 && n*float64(unit) < math.MaxInt64 {
+			return now.Add(-time.Duration(n * float64(unit))), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid age %q (expected e.g. 7d, 2w, 36h or 2024-01-31)", value)
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ezrantn/trc"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "512", expected: 512},
+		{input: "0", expected: 0},
+		{input: "10B", expected: 10},
+		{input: "10KB", expected: 10_000},
+		{input: "10kb", expected: 10_000},
+		{input: "10 MB", expected: 10_000_000},
+		{input: "1.5GiB", expected: 3 << 29},
+		{input: "2T", expected: 2_000_000_000_000},
+		{input: "1TiB", expected: 1 << 40},
+		{input: " 7K ", expected: 7000},
+		{input: "", wantErr: true},
+		{input: "MB", wantErr: true},
+		{input: "10XB", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "1.2.3", wantErr: true},
+		{input: "8388608TiB", wantErr: true},
+		{input: "9223372036854775807", wantErr: true},
+		{input: "99999999999TB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.expected {
+				t.Errorf("parseSize(%q) = %d, want %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{input: "7d", expected: now.AddDate(0, 0, -7)},
+		{input: "2w", expected: now.AddDate(0, 0, -14)},
+		{input: "1.5d", expected: now.Add(-36 * time.Hour)},
+		{input: "36h", expected: now.Add(-36 * time.Hour)},
+		{input: "90m", expected: now.Add(-90 * time.Minute)},
+		{input: "0d", expected: now},
+		{input: "2024-01-31", expected: time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)},
+		{input: "2024-01-31T08:30:00", expected: time.Date(2024, 1, 31, 8, 30, 0, 0, time.Local)},
+		{input: "2024-01-31T08:30:00Z", expected: time.Date(2024, 1, 31, 8, 30, 0, 0, time.UTC)},
+		{input: "", wantErr: true},
+		{input: "d", wantErr: true},
+		{input: "-7d", wantErr: true},
+		{input: "-1h", wantErr: true},
+		{input: "7y", wantErr: true},
+		{input: "2024-13-01", wantErr: true},
+		{input: "999999999w", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseAge(tt.input, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAge(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !got.Equal(tt.expected) {
+				t.Errorf("parseAge(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSelection(t *testing.T) {
+	var config trc.PartitionConfig
+	err := parseSelection(&config, "99999999999TB", "", "", "")
+	if err == nil || !strings.HasPrefix(err.Error(), "--min-size: invalid size") {
+		t.Errorf("expected an overflowing size to be rejected by --min-size, got %v", err)
+	}
+
+	if err := parseSelection(&config, "1KB", "1MiB", "7d", ""); err != nil {
+		t.Fatalf("parseSelection failed: %v", err)
+	}
+
+	if config.MinSize != 1000 || config.MaxSize != 1<<20 || config.ModifiedAfter.IsZero() {
+		t.Errorf("unexpected selection %d, %d, %v", config.MinSize, config.MaxSize, config.ModifiedAfter)
+	}
+}
@@ -0,0 +1,17 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ezrantn/trc"
+)
+
+// PrintVerifyReport writes every problem found by trc.VerifyPartitions followed by a summary.
+func PrintVerifyReport(w io.Writer, report *trc.VerifyReport) {
+	for _, problem := range report.Problems {
+		fmt.Fprintf(w, "%sMISMATCH:%s %v\n", trc.Yellow, trc.Reset, problem)
+	}
+
+	fmt.Fprintf(w, "%d links checked, %d problem(s)\n", report.Checked, len(report.Problems))
+}
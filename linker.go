@@ -0,0 +1,315 @@
+package trc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// LinkMode selects how files are placed inside their partitions.
+type LinkMode int
+
+const (
+	LinkSymlink  LinkMode = iota // Symbolic link to the source (default)
+	LinkHardlink                 // Hard link to the source, which must be on the same file system
+	LinkReflink                  // Copy-on-write clone of the source, or a plain copy where cloning is not supported
+	LinkCopy                     // Plain copy of the source
+	LinkMove                     // Move the source into the partition
+)
+
+var linkModeNames = map[LinkMode]string{
+	LinkSymlink:  "symlink",
+	LinkHardlink: "hardlink",
+	LinkReflink:  "reflink",
+	LinkCopy:     "copy",
+	LinkMove:     "move",
+}
+
+// String returns the name of the mode as accepted by ParseLinkMode.
+func (m LinkMode) String() string {
+	return enumString(linkModeNames, "LinkMode", m)
+}
+
+func (m LinkMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *LinkMode) UnmarshalText(text []byte) error {
+	return unmarshalEnum(m, text, ParseLinkMode)
+}
+
+// ParseLinkMode converts a mode name (symlink, hardlink, reflink, copy, move) into a LinkMode.
+func ParseLinkMode(name string) (LinkMode, error) {
+	return parseEnum(linkModeNames, "link mode", name)
+}
+
+// Linker places source files inside partitions and takes them out again.
+type Linker interface {
+	// Link places source at dest. Nothing exists at dest yet and its parent directory does.
+	Link(source, dest string) error
+
+	// Same reports whether dest already is the placement of source, so it can be kept as is.
+	Same(source, dest string) bool
+
+	// Verify checks that dest still matches the link recorded in the manifest.
+	Verify(dest string, link ManifestLink) error
+
+	// Remove takes dest out of the partition. Files that hold the only remaining copy of their
+	// source, or that changed since they were linked, are kept and reported as an error.
+	Remove(dest string, link ManifestLink) error
+}
+
+// NewLinker returns the built-in Linker for a mode. Symlinks created by it use absolute targets
+// when given an absolute source.
+func NewLinker(mode LinkMode) (Linker, error) {
+	return newLinker(mode, false)
+}
+
+// newLinker returns the built-in Linker for a mode, creating relative symlinks if relative is set.
+func newLinker(mode LinkMode, relative bool) (Linker, error) {
+	switch mode {
+	case LinkSymlink:
+		return symlinkLinker{relative: relative}, nil
+	case LinkHardlink:
+		return hardlinkLinker{}, nil
+	case LinkReflink:
+		return copyLinker{clone: true}, nil
+	case LinkCopy:
+		return copyLinker{}, nil
+	case LinkMove:
+		return moveLinker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown link mode %s", mode)
+	}
+}
+
+// symlinkLinker places a symbolic link to the source, with a target relative to the link's
+// directory if relative is set.
+type symlinkLinker struct {
+	relative bool
+}
+
+func (l symlinkLinker) Link(source, dest string) error {
+	if err := os.Symlink(symlinkTarget(dest, source, l.relative), dest); err != nil {
+		return fmt.Errorf("failed to create symlink from %s to %s: %w", source, dest, err)
+	}
+	return nil
+}
+
+func (l symlinkLinker) Same(source, dest string) bool {
+	target, err := os.Readlink(dest)
+	return err == nil && target == symlinkTarget(dest, source, l.relative)
+}
+
+func (symlinkLinker) Verify(dest string, link ManifestLink) error {
+	target, err := os.Readlink(dest)
+	if err != nil {
+		return fmt.Errorf("not a symlink: %w", err)
+	}
+
+	if !pointsTo(dest, link) {
+		return fmt.Errorf("points to %s instead of %s", target, link.Target)
+	}
+
+	if _, err := os.Stat(dest); err != nil {
+		return fmt.Errorf("source is missing: %w", err)
+	}
+	return nil
+}
+
+func (symlinkLinker) Remove(dest string, link ManifestLink) error {
+	return removeLinkTo(dest, link)
+}
+
+// hardlinkLinker places a hard link to the source.
+type hardlinkLinker struct{}
+
+func (hardlinkLinker) Link(source, dest string) error {
+	if err := os.Link(source, dest); err != nil {
+		return fmt.Errorf("failed to create hard link from %s to %s: %w", source, dest, err)
+	}
+	return nil
+}
+
+func (hardlinkLinker) Same(source, dest string) bool {
+	return sameFile(source, dest)
+}
+
+func (hardlinkLinker) Verify(dest string, link ManifestLink) error {
+	if _, err := os.Stat(link.Target); err != nil {
+		return fmt.Errorf("source is missing: %w", err)
+	}
+
+	if !sameFile(link.Target, dest) {
+		return fmt.Errorf("is no longer a hard link to %s", link.Target)
+	}
+	return nil
+}
+
+func (l hardlinkLinker) Remove(dest string, link ManifestLink) error {
+	if err := l.Verify(dest, link); err != nil {
+		return fmt.Errorf("kept %s: %w", dest, err)
+	}
+
+	if err := os.Remove(dest); err != nil {
+		return fmt.Errorf("failed to remove hard link %s: %w", dest, err)
+	}
+	return nil
+}
+
+// copyLinker places a copy of the source, cloned where the file system supports it when clone
+// is set. The copy keeps the modification time of the source, which is how unchanged copies
+// are recognized later.
+type copyLinker struct {
+	clone bool
+}
+
+func (l copyLinker) Link(source, dest string) error {
+	if err := copyFile(source, dest, l.clone); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", source, dest, err)
+	}
+	return nil
+}
+
+func (copyLinker) Same(source, dest string) bool {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false
+	}
+
+	return unchanged(dest, sourceInfo.Size(), sourceInfo.ModTime()) == nil
+}
+
+func (copyLinker) Verify(dest string, link ManifestLink) error {
+	return unchanged(dest, link.Size, link.ModTime)
+}
+
+func (l copyLinker) Remove(dest string, link ManifestLink) error {
+	if err := l.Verify(dest, link); err != nil {
+		return fmt.Errorf("kept %s: %w", dest, err)
+	}
+
+	if _, err := os.Stat(link.Target); err != nil {
+		return fmt.Errorf("kept %s: it is the only copy left of %s", dest, link.Target)
+	}
+
+	if err := os.Remove(dest); err != nil {
+		return fmt.Errorf("failed to remove copy %s: %w", dest, err)
+	}
+	return nil
+}
+
+// moveLinker moves the source into the partition. Removing it moves the file back.
+type moveLinker struct{}
+
+func (moveLinker) Link(source, dest string) error {
+	err := os.Rename(source, dest)
+	if err == nil {
+		return nil
+	}
+
+	// Renaming fails across file systems, copy and delete the source instead
+	if !errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("failed to move %s to %s: %w", source, dest, err)
+	}
+
+	if err := copyFile(source, dest, false); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", source, dest, err)
+	}
+
+	if err := os.Remove(source); err != nil {
+		return fmt.Errorf("failed to remove %s after moving it: %w", source, err)
+	}
+	return nil
+}
+
+func (moveLinker) Same(source, dest string) bool {
+	return false
+}
+
+func (moveLinker) Verify(dest string, link ManifestLink) error {
+	return unchanged(dest, link.Size, link.ModTime)
+}
+
+func (moveLinker) Remove(dest string, link ManifestLink) error {
+	if _, err := os.Lstat(link.Target); err == nil {
+		return fmt.Errorf("kept %s: cannot move it back, %s already exists", dest, link.Target)
+	}
+
+	if err := ensureDirectory(filepath.Dir(link.Target)); err != nil {
+		return err
+	}
+
+	return moveLinker{}.Link(dest, link.Target)
+}
+
+// copyFile copies source to dest with its permissions and modification time. With clone set the
+// data is cloned where the file system supports it. A partial copy is removed on failure.
+func copyFile(source, dest string, clone bool) (err error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+
+	if !clone || cloneFile(in, out) != nil {
+		if _, err := io.Copy(out, in); err != nil {
+			return err
+		}
+	}
+
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+// unchanged checks that path is a regular file with the given size and modification time. Times
+// are compared to the second, as some file systems store nothing finer.
+func unchanged(path string, size int64, modTime time.Time) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+
+	if info.Size() != size || info.ModTime().Unix() != modTime.Unix() {
+		return errors.New("changed since it was linked")
+	}
+	return nil
+}
+
+// sameFile reports whether both paths refer to the same file.
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	bInfo, err := os.Lstat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
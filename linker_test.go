@@ -0,0 +1,211 @@
+package trc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkModes(t *testing.T) {
+	tests := []struct {
+		mode  LinkMode
+		check func(t *testing.T, source, dest string)
+	}{
+		{
+			mode: LinkSymlink,
+			check: func(t *testing.T, source, dest string) {
+				if target, err := os.Readlink(dest); err != nil || target != source {
+					t.Errorf("expected a symlink to %s, got %q (%v)", source, target, err)
+				}
+			},
+		},
+		{
+			mode: LinkHardlink,
+			check: func(t *testing.T, source, dest string) {
+				if !sameFile(source, dest) {
+					t.Errorf("expected %s to be a hard link to %s", dest, source)
+				}
+			},
+		},
+		{mode: LinkReflink, check: checkCopy},
+		{mode: LinkCopy, check: checkCopy},
+		{
+			mode: LinkMove,
+			check: func(t *testing.T, source, dest string) {
+				if _, err := os.Lstat(source); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be moved away", source)
+				}
+				checkContent(t, dest)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+
+			source := filepath.Join(sourceDir, "a.txt")
+			if err := os.WriteFile(source, []byte("content of a"), 0644); err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+
+			config := PartitionConfig{
+				SourceDir:  sourceDir,
+				OutputDirs: []string{filepath.Join(tempDir, "partition1")},
+				ByFile:     true,
+				LinkMode:   tt.mode,
+			}
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("Partitioning failed: %v", err)
+			}
+
+			dest := filepath.Join(config.OutputDirs[0], "a.txt")
+			tt.check(t, source, dest)
+
+			// Running again keeps what is already in place
+			if tt.mode != LinkMove {
+				if err := MakePartitions(config); err != nil {
+					t.Fatalf("Partitioning again failed: %v", err)
+				}
+			}
+
+			manifest, err := ReadManifest(config.OutputDirs[0])
+			if err != nil {
+				t.Fatalf("ReadManifest failed: %v", err)
+			}
+
+			if manifest.Mode != tt.mode {
+				t.Errorf("expected mode %s in the manifest, got %s", tt.mode, manifest.Mode)
+			}
+
+			report, err := VerifyPartitions(config)
+			if err != nil {
+				t.Fatalf("VerifyPartitions failed: %v", err)
+			}
+
+			if report.Checked != 1 || !report.OK() {
+				t.Errorf("unexpected verify report: %+v", report)
+			}
+
+			if err := RemovePartitionsWithConfig(config); err != nil {
+				t.Fatalf("RemovePartitionsWithConfig failed: %v", err)
+			}
+
+			checkContent(t, source)
+			if _, err := os.Stat(config.OutputDirs[0]); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be removed", config.OutputDirs[0])
+			}
+		})
+	}
+}
+
+func TestRemovePartitionsKeepsModifiedCopies(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("content of a"), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+
+	config := PartitionConfig{
+		SourceDir:  sourceDir,
+		OutputDirs: []string{filepath.Join(tempDir, "partition1")},
+		ByFile:     true,
+		LinkMode:   LinkCopy,
+	}
+
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("Partitioning failed: %v", err)
+	}
+
+	modified := filepath.Join(config.OutputDirs[0], "a.txt")
+	if err := os.WriteFile(modified, []byte("edited in the partition"), 0644); err != nil {
+		t.Fatalf("error editing copy: %v", err)
+	}
+
+	report, err := VerifyPartitions(config)
+	if err != nil {
+		t.Fatalf("VerifyPartitions failed: %v", err)
+	}
+
+	if len(report.Problems) != 1 || report.Problems[0].Path != modified {
+		t.Errorf("expected %s to be reported, got %+v", modified, report.Problems)
+	}
+
+	if err := RemovePartitionsWithConfig(config); err == nil {
+		t.Errorf("expected an error for the modified copy")
+	}
+
+	if _, err := os.Stat(modified); err != nil {
+		t.Errorf("modified copy was removed: %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(config.OutputDirs[0], "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the unchanged copy to be removed")
+	}
+
+	manifest, err := ReadManifest(config.OutputDirs[0])
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+
+	if len(manifest.Links) != 1 || manifest.Links[0].Path != "a.txt" {
+		t.Errorf("expected only the kept copy in the manifest, got %+v", manifest.Links)
+	}
+}
+
+func TestLinkModeText(t *testing.T) {
+	for mode := range linkModeNames {
+		data, err := json.Marshal(mode)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+
+		var decoded LinkMode
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal of %s failed: %v", data, err)
+		}
+
+		if decoded != mode {
+			t.Errorf("expected %s, got %s", mode, decoded)
+		}
+	}
+
+	if _, err := ParseLinkMode("teleport"); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
+
+func checkCopy(t *testing.T, source, dest string) {
+	t.Helper()
+
+	info, err := os.Lstat(dest)
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("expected a regular file at %s: %v", dest, err)
+	}
+
+	if sameFile(source, dest) {
+		t.Errorf("expected %s to be a separate copy of %s", dest, source)
+	}
+	checkContent(t, dest)
+}
+
+func checkContent(t *testing.T, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "content of a" {
+		t.Errorf("unexpected content of %s: %q (%v)", path, data, err)
+	}
+}
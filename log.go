@@ -0,0 +1,27 @@
+package trc
+
+import (
+	"log/slog"
+	"time"
+)
+
+// slowOperation is how long a single directory read, MIME detection or link may take before it
+// is logged as slow.
+const slowOperation = time.Second
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// newLogger returns the logger of the configuration, or one that discards everything.
+func newLogger(config PartitionConfig) *slog.Logger {
+	if config.Logger == nil {
+		return discardLogger
+	}
+	return config.Logger
+}
+
+// logSlow logs an operation that started at start if it took longer than slowOperation.
+func logSlow(log *slog.Logger, start time.Time, msg string, args ...any) {
+	if elapsed := time.Since(start); elapsed >= slowOperation {
+		log.Info(msg, append(args, "duration", elapsed)...)
+	}
+}
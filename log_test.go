@@ -0,0 +1,110 @@
+package trc
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   PartitionConfig
+		remove   bool     // Remove the partitions after creating them
+		expected []string // Level and message of records that must be logged
+	}{
+		{
+			name:     "Links",
+			config:   PartitionConfig{ByFile: true, CollisionPolicy: CollisionRenameSuffix},
+			expected: []string{"WARN link path collision", "DEBUG linked file", "INFO wrote manifest"},
+		},
+		{
+			name:     "Skipped collisions",
+			config:   PartitionConfig{ByFile: true, CollisionPolicy: CollisionSkip},
+			expected: []string{"WARN skipped file, its link path is taken"},
+		},
+		{
+			name:     "Excluded files",
+			config:   PartitionConfig{ByFile: true, PreserveTree: true, Exclude: []string{"b/**"}, MinSize: 1},
+			expected: []string{"DEBUG skipped directory", "DEBUG skipped file"},
+		},
+		{
+			name:     "Invalid names",
+			config:   PartitionConfig{ByFile: true, PreserveTree: true, NameProfile: NameProfileWindows, InvalidNamePolicy: InvalidNameSkip},
+			expected: []string{"WARN skipped file with invalid name"},
+		},
+		{
+			name:     "Types",
+			config:   PartitionConfig{PreserveTree: true},
+			expected: []string{"DEBUG skipped file", "DEBUG linked file"},
+		},
+		{
+			name:     "Remove",
+			config:   PartitionConfig{ByFile: true, PreserveTree: true},
+			remove:   true,
+			expected: []string{"DEBUG removed link", "INFO removed partition"},
+		},
+	}
+
+	sourceDir := t.TempDir()
+	files := map[string]string{
+		"a/same.txt":  "first",
+		"b/same.txt":  "second",
+		"a/empty":     "",
+		"a/aux.txt":   "reserved on Windows",
+		"b/notes.txt": "notes",
+	}
+	for path, content := range files {
+		path = filepath.Join(sourceDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			config := tt.config
+			config.SourceDir = sourceDir
+			config.OutputDirs = []string{filepath.Join(t.TempDir(), "partition1")}
+			config.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("MakePartitions failed: %v", err)
+			}
+
+			if tt.remove {
+				if err := RemovePartitionsWithConfig(config); err != nil {
+					t.Fatalf("RemovePartitionsWithConfig failed: %v", err)
+				}
+			}
+
+			var logged []string
+			decoder := json.NewDecoder(&buf)
+			for decoder.More() {
+				var record struct {
+					Level string `json:"level"`
+					Msg   string `json:"msg"`
+				}
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatalf("failed to decode log record: %v", err)
+				}
+				logged = append(logged, record.Level+" "+record.Msg)
+			}
+
+			for _, expected := range tt.expected {
+				if !slices.Contains(logged, expected) {
+					t.Errorf("expected %q to be logged, got %v", expected, logged)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package trc
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ManifestDir  = ".trc"          // Directory created inside every partition for trc metadata
+	ManifestFile = "manifest.json" // Name of the manifest file inside ManifestDir
+)
+
+// Names of the built-in partitioning strategies
+const (
+	strategyCount = "count"
+	strategySize  = "size"
+	strategyMime  = "mime"
+	strategyHash  = "hash"
+)
+
+// Manifest records what trc created inside a single partition directory.
+type Manifest struct {
+	Version   string          `json:"version"`    // trc version that created the partition
+	SourceDir string          `json:"source_dir"` // Absolute path of the source directory
+	Strategy  string          `json:"strategy"`   // Name of the partitioning strategy, such as count, size, mime or hash
+	Mode      LinkMode        `json:"mode"`       // How the files were placed in the partition
+	Partition int             `json:"partition"`  // Index of this partition in Config.OutputDirs
+	Config    PartitionConfig `json:"config"`     // Configuration of the run
+	CreatedAt time.Time       `json:"created_at"` // When the manifest was written
+	Links     []ManifestLink  `json:"links"`      // Every link in the partition, sorted by path
+}
+
+// ManifestLink describes a single link created by trc. With link modes other than symlink the
+// "link" is a hard link, a copy or the moved file itself.
+type ManifestLink struct {
+	Path     string    `json:"path"`               // Link path relative to the partition directory, using forward slashes
+	Target   string    `json:"target"`             // File the link points to, or where a moved file came from
+	Relative string    `json:"relative,omitempty"` // Target written into the symlink, when it is relative
+	Size     int64     `json:"size"`               // Size of the target when the link was created
+	ModTime  time.Time `json:"mtime"`              // Modification time of the target when the link was created
+}
+
+// ManifestPath returns the location of the manifest for the given partition directory.
+func ManifestPath(outputDir string) string {
+	return filepath.Join(outputDir, ManifestDir, ManifestFile)
+}
+
+// ReadManifest loads the manifest of a partition directory. The returned error wraps
+// os.ErrNotExist if the directory has no manifest.
+func ReadManifest(outputDir string) (*Manifest, error) {
+	data, err := os.ReadFile(ManifestPath(outputDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %s: %w", outputDir, err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %w", outputDir, err)
+	}
+
+	return &manifest, nil
+}
+
+// WriteManifest writes the manifest of a partition directory, replacing any previous one.
+func WriteManifest(outputDir string, manifest *Manifest) error {
+	return writeManifest(outputDir, manifest, func(fn func(ManifestLink) error) error {
+		for _, link := range manifest.Links {
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeManifest writes a manifest whose links are handed over one at a time by each, so a
+// partition with millions of links is never held in memory. The header fields come from
+// manifest, its Links are ignored. The output is the same as encoding the whole manifest at
+// once, and nothing is replaced if each fails.
+func writeManifest(outputDir string, manifest *Manifest, each func(fn func(ManifestLink) error) error) error {
+	if err := ensureDirectory(filepath.Join(outputDir, ManifestDir)); err != nil {
+		return err
+	}
+
+	header := *manifest
+	header.Links = []ManifestLink{}
+	data, err := json.MarshalIndent(&header, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest of %s: %w", outputDir, err)
+	}
+
+	// Links is the last field, so the encoding ends with an empty array to fill in
+	const emptyLinks = "[]\n}"
+	if !bytes.HasSuffix(data, []byte(emptyLinks)) {
+		return fmt.Errorf("failed to encode manifest of %s: unexpected layout", outputDir)
+	}
+
+	// Write to a temporary file first so readers never see a truncated manifest
+	path := ManifestPath(outputDir)
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+
+	w := bufio.NewWriter(file)
+	w.Write(data[:len(data)-len(emptyLinks)])
+	w.WriteString("[")
+
+	count := 0
+	err = each(func(link ManifestLink) error {
+		encoded, err := json.MarshalIndent(link, "    ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest of %s: %w", outputDir, err)
+		}
+
+		if count > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString("\n    ")
+		w.Write(encoded)
+		count++
+		return nil
+	})
+
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if count > 0 {
+		w.WriteString("\n  ")
+	}
+	w.WriteString("]\n}\n")
+
+	if err := errors.Join(w.Flush(), file.Close()); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// eachManifestLink calls fn for every link listed in the manifest of a partition directory,
+// decoding them one at a time. The returned error wraps os.ErrNotExist if the directory has no
+// manifest.
+func eachManifestLink(outputDir string, fn func(ManifestLink) error) error {
+	file, err := os.Open(ManifestPath(outputDir))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of %s: %w", outputDir, err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	parseErr := func(err error) error {
+		return fmt.Errorf("failed to parse manifest of %s: %w", outputDir, err)
+	}
+
+	if token, err := decoder.Token(); err != nil {
+		return parseErr(err)
+	} else if token != json.Delim('{') {
+		return parseErr(errors.New("not a JSON object"))
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return parseErr(err)
+		}
+
+		if key != "links" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return parseErr(err)
+			}
+			continue
+		}
+
+		if token, err := decoder.Token(); err != nil {
+			return parseErr(err)
+		} else if token != json.Delim('[') {
+			continue
+		}
+
+		for decoder.More() {
+			var link ManifestLink
+			if err := decoder.Decode(&link); err != nil {
+				return parseErr(err)
+			}
+
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+
+		if _, err := decoder.Token(); err != nil {
+			return parseErr(err)
+		}
+	}
+
+	return nil
+}
+
+// manifestBuilder collects the links created in each partition during a run. Links are kept
+// in a spillSorter, so the manifests of very large runs go through temporary files instead of
+// memory.
+type manifestBuilder struct {
+	config    PartitionConfig
+	strategy  string
+	sourceDir string
+	dirs      []string // Where the partitions are built: OutputDirs, or their staging directories
+
+	mu    sync.Mutex                     // Links are added by several workers at once
+	seq   int64                          // Number of links added so far
+	links []*spillSorter[manifestRecord] // One per partition
+}
+
+// manifestRecord is a link recorded by a manifestBuilder. Seq orders the records of the same
+// path: links listed by an earlier manifest have 0, so any link of the run replaces them, and
+// among the links of the run the last one wins.
+type manifestRecord struct {
+	Seq  int64        `json:"seq"`
+	Link ManifestLink `json:"link"`
+}
+
+func compareManifestRecords(a, b manifestRecord) int {
+	if c := strings.Compare(a.Link.Path, b.Link.Path); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Seq, b.Seq)
+}
+
+func newManifestBuilder(config PartitionConfig, strategy string) *manifestBuilder {
+	// Plans read from CSV do not know their source directory, record nothing rather than a guess
+	sourceDir := config.SourceDir
+	if sourceDir != "" {
+		if abs, err := filepath.Abs(sourceDir); err == nil {
+			sourceDir = abs
+		}
+	}
+
+	links := make([]*spillSorter[manifestRecord], len(config.OutputDirs))
+	for i := range links {
+		links[i] = newSpillSorter(config.SpillDir, compareManifestRecords)
+	}
+
+	return &manifestBuilder{config: config, strategy: strategy, sourceDir: sourceDir, dirs: config.OutputDirs, links: links}
+}
+
+// entry describes a link about to be created inside the given partition.
+func (b *manifestBuilder) entry(partition int, linkPath, target string) (ManifestLink, error) {
+	relPath, err := filepath.Rel(b.dirs[partition], linkPath)
+	if err != nil {
+		return ManifestLink{}, fmt.Errorf("failed to resolve %s relative to %s: %w", linkPath, b.dirs[partition], err)
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	link := ManifestLink{Path: relPath, Target: target}
+	if b.config.LinkMode == LinkSymlink && b.config.RelativeLinks {
+		if relTarget := symlinkTarget(linkPath, target, true); !filepath.IsAbs(relTarget) {
+			link.Relative = relTarget
+		}
+	}
+
+	// A target that cannot be read is still linked, it is just recorded without size and mtime
+	if info, err := os.Stat(target); err == nil {
+		link.Size = info.Size()
+		link.ModTime = info.ModTime().UTC()
+	}
+
+	return link, nil
+}
+
+// add records a link created inside the given partition.
+func (b *manifestBuilder) add(partition int, link ManifestLink) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	return b.links[partition].add(manifestRecord{Seq: b.seq, Link: link})
+}
+
+// write writes the manifest of every partition. Links recorded by an earlier run are kept as
+// long as they still exist and were not replaced by this run.
+func (b *manifestBuilder) write() error {
+	defer b.close()
+
+	createdAt := time.Now().UTC()
+	for i, dir := range b.dirs {
+		links := b.links[i]
+
+		err := eachManifestLink(dir, func(link ManifestLink) error {
+			return links.add(manifestRecord{Link: link})
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		manifest := &Manifest{
+			Version:   Version,
+			SourceDir: b.sourceDir,
+			Strategy:  b.strategy,
+			Mode:      b.config.LinkMode,
+			Partition: i,
+			Config:    b.config,
+			CreatedAt: createdAt,
+		}
+
+		merged := func(fn func(ManifestLink) error) error {
+			var last *manifestRecord
+			flush := func() error {
+				if last == nil {
+					return nil
+				}
+
+				// Links of an earlier run are only kept if they are still there
+				if last.Seq == 0 {
+					if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(last.Link.Path))); err != nil {
+						return nil
+					}
+				}
+				return fn(last.Link)
+			}
+
+			err := links.each(func(record manifestRecord) error {
+				if last != nil && last.Link.Path != record.Link.Path {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+
+				last = &record
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return flush()
+		}
+
+		if err := writeManifest(dir, manifest, merged); err != nil {
+			return err
+		}
+		newLogger(b.config).Info("wrote manifest", "partition", i, "dir", dir)
+	}
+
+	return nil
+}
+
+// close removes the temporary files of the builder.
+func (b *manifestBuilder) close() error {
+	var errs []error
+	for _, links := range b.links {
+		errs = append(errs, links.close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package trc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMakePartitionsWritesManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	files := map[string]int{"a.txt": 10, "b.txt": 20, "c.txt": 30}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+
+	config := PartitionConfig{
+		SourceDir:  sourceDir,
+		OutputDirs: []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2")},
+		BySize:     true,
+	}
+
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("Partitioning failed: %v", err)
+	}
+
+	total := 0
+	for i, dir := range config.OutputDirs {
+		manifest, err := ReadManifest(dir)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %v", err)
+		}
+
+		if manifest.Version != Version || manifest.Strategy != strategySize || manifest.Partition != i {
+			t.Errorf("unexpected manifest header: %+v", manifest)
+		}
+
+		if manifest.SourceDir != sourceDir {
+			t.Errorf("expected source dir %s, got %s", sourceDir, manifest.SourceDir)
+		}
+
+		for _, link := range manifest.Links {
+			target, err := os.Readlink(filepath.Join(dir, filepath.FromSlash(link.Path)))
+			if err != nil {
+				t.Errorf("manifest lists %s but the link does not exist: %v", link.Path, err)
+				continue
+			}
+
+			if target != link.Target {
+				t.Errorf("manifest target %s does not match link target %s", link.Target, target)
+			}
+
+			if link.Size != int64(files[filepath.Base(link.Target)]) {
+				t.Errorf("manifest size of %s is %d, expected %d", link.Path, link.Size, files[filepath.Base(link.Target)])
+			}
+
+			if link.ModTime.IsZero() {
+				t.Errorf("manifest mtime of %s is not set", link.Path)
+			}
+		}
+
+		total += len(manifest.Links)
+	}
+
+	if total != len(files) {
+		t.Errorf("expected %d links in manifests, got %d", len(files), total)
+	}
+
+	// Running again must not link the manifests themselves or lose any entries
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("Partitioning failed on second run: %v", err)
+	}
+
+	total = 0
+	for _, dir := range config.OutputDirs {
+		manifest, err := ReadManifest(dir)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %v", err)
+		}
+		total += len(manifest.Links)
+	}
+
+	if total != len(files) {
+		t.Errorf("expected %d links in manifests after second run, got %d", len(files), total)
+	}
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	if _, err := ReadManifest(t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not-exist error, got: %v", err)
+	}
+}
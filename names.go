@@ -0,0 +1,265 @@
+package trc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// InvalidNamePolicy decides what happens to a file whose name is not a valid link name, such as
+// user@host.log or CON.txt.
+type InvalidNamePolicy int
+
+const (
+	InvalidNameFail     InvalidNamePolicy = iota // Abort with an error (default)
+	InvalidNameSkip                              // Leave the file out and report it
+	InvalidNameSanitize                          // Link the file under a valid name derived from its own
+)
+
+var invalidNamePolicyNames = map[InvalidNamePolicy]string{
+	InvalidNameFail:     "fail",
+	InvalidNameSkip:     "skip",
+	InvalidNameSanitize: "sanitize",
+}
+
+// String returns the name of the policy as accepted by ParseInvalidNamePolicy.
+func (p InvalidNamePolicy) String() string {
+	return enumString(invalidNamePolicyNames, "InvalidNamePolicy", p)
+}
+
+func (p InvalidNamePolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *InvalidNamePolicy) UnmarshalText(text []byte) error {
+	return unmarshalEnum(p, text, ParseInvalidNamePolicy)
+}
+
+// ParseInvalidNamePolicy converts a policy name (fail, skip, sanitize) into an InvalidNamePolicy.
+func ParseInvalidNamePolicy(name string) (InvalidNamePolicy, error) {
+	return parseEnum(invalidNamePolicyNames, "invalid name policy", name)
+}
+
+// InvalidName describes a file whose name is not a valid link name.
+type InvalidName struct {
+	Path      string            `json:"path"`                // File with the invalid name
+	Reason    string            `json:"reason"`              // Why the name is invalid
+	Sanitized string            `json:"sanitized,omitempty"` // Name the file is linked as, its path below the partition with PreserveTree; empty if it was skipped or the run failed
+	Policy    InvalidNamePolicy `json:"policy"`              // Policy that was applied
+}
+
+// InvalidNameError is returned for a file whose name is not a valid link name when the
+// InvalidNamePolicy is InvalidNameFail.
+type InvalidNameError struct {
+	Path      string // File with the invalid name
+	Partition int    // Always -1: names are checked while walking, before files are assigned to partitions
+	Err       error  // Why the name is invalid
+}
+
+func (e *InvalidNameError) Error() string {
+	return "invalid file name " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *InvalidNameError) Unwrap() error {
+	return e.Err
+}
+
+// NameProfile selects the rules link names are checked against before InvalidNamePolicy applies.
+type NameProfile int
+
+// The profiles from NameProfilePOSIX to NameProfileFAT are ordered from the least to the most strict.
+const (
+	NameProfileAuto    NameProfile = iota // Rules of the file system holding the output directories (default)
+	NameProfilePOSIX                      // Only "/" and NUL are forbidden, names are limited to 255 bytes
+	NameProfileWindows                    // No control characters, \ / : * ? " < > |, device names or trailing dots and spaces
+	NameProfileFAT                        // Windows rules plus @, ! and the reserved names of DOS and NTFS, for FAT and exFAT
+	NameProfileNone                       // Every name is accepted
+)
+
+var nameProfileNames = map[NameProfile]string{
+	NameProfileAuto:    "auto",
+	NameProfilePOSIX:   "posix",
+	NameProfileWindows: "windows",
+	NameProfileFAT:     "fat",
+	NameProfileNone:    "none",
+}
+
+// String returns the name of the profile as accepted by ParseNameProfile.
+func (p NameProfile) String() string {
+	return enumString(nameProfileNames, "NameProfile", p)
+}
+
+func (p NameProfile) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *NameProfile) UnmarshalText(text []byte) error {
+	return unmarshalEnum(p, text, ParseNameProfile)
+}
+
+// ParseNameProfile converts a profile name (auto, posix, windows, fat, none) into a NameProfile.
+func ParseNameProfile(name string) (NameProfile, error) {
+	return parseEnum(nameProfileNames, "name profile", name)
+}
+
+// Device names reserved by Windows in every directory, whatever the extension
+const windowsDeviceNames = "CON PRN AUX NUL CONIN$ CONOUT$ COM0 COM1 COM2 COM3 COM4 COM5 COM6 COM7 COM8 COM9 LPT0 LPT1 LPT2 LPT3 LPT4 LPT5 LPT6 LPT7 LPT8 LPT9"
+
+// nameRules are the rules of a NameProfile.
+type nameRules struct {
+	invalidChars *regexp.Regexp // Characters that cannot appear in a name, nil if any can
+	reserved     []string       // Names that cannot be used whatever their case and extension
+	maxLength    int            // Maximum length in bytes, 0 if unlimited
+	noTrailing   bool           // Names cannot end with a dot or a space
+}
+
+var nameProfileRules = map[NameProfile]nameRules{
+	NameProfilePOSIX: {
+		invalidChars: regexp.MustCompile(`[\x00/]`),
+		maxLength:    maxLength,
+	},
+	NameProfileWindows: {
+		invalidChars: regexp.MustCompile(`[\x00-\x1F\\/:*?"<>|]`),
+		reserved:     strings.Fields(windowsDeviceNames),
+		maxLength:    maxLength,
+		noTrailing:   true,
+	},
+	NameProfileFAT: {
+		invalidChars: characterFilterRegex,
+		reserved:     strings.Fields(dosReservedNames + " " + windowsReservedNames),
+		maxLength:    maxLength,
+		noTrailing:   true,
+	},
+	NameProfileNone: {},
+}
+
+// resolveNameProfile returns the profile link names are checked against. NameProfileAuto picks
+// the strictest profile among the file systems of the output directories, and falls back to the
+// FAT rules when one of them cannot be identified.
+func resolveNameProfile(profile NameProfile, outputDirs []string) NameProfile {
+	if profile != NameProfileAuto {
+		return profile
+	}
+
+	if len(outputDirs) == 0 {
+		return NameProfileFAT
+	}
+
+	resolved := NameProfilePOSIX
+	for _, dir := range outputDirs {
+		dirProfile, ok := fileSystemNameProfile(existingDir(dir))
+		if !ok {
+			return NameProfileFAT
+		}
+		resolved = max(resolved, dirProfile)
+	}
+
+	return resolved
+}
+
+// existingDir returns dir, or its closest ancestor that exists when dir has not been created yet.
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// validate checks a file name against the rules.
+func (r nameRules) validate(filename string) error {
+	if r.noTrailing {
+		if hasTrailingDotOrSpace(filename) {
+			return errors.New("filename has trailing dots or spaces")
+		}
+
+		filename = strings.TrimSpace(filename)
+	}
+
+	if filename == "" {
+		return errors.New("filename cannot be empty")
+	}
+
+	if r.maxLength > 0 && len(filename) > r.maxLength {
+		return errors.New("filename exceeds maximum length")
+	}
+
+	if r.invalidChars != nil && r.invalidChars.MatchString(filename) {
+		return errors.New("filename contains invalid characters")
+	}
+
+	if r.isReserved(filenameWithoutExtension(filename)) {
+		return errors.New("filename is a reserved name")
+	}
+
+	return nil
+}
+
+func (r nameRules) isReserved(baseName string) bool {
+	for _, reservedName := range r.reserved {
+		if strings.EqualFold(baseName, reservedName) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize derives a valid link name from an invalid file name: invalid characters are replaced
+// with underscores, trailing dots and spaces are dropped, reserved names get an underscore
+// appended and long names are shortened, keeping their extension. Names with nothing left
+// become defaultName.
+func (r nameRules) sanitize(filename string) string {
+	name := filename
+	if r.invalidChars != nil {
+		name = r.invalidChars.ReplaceAllString(name, "_")
+	}
+
+	if r.noTrailing {
+		name = strings.TrimRight(name, ". ")
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	if r.isReserved(base) {
+		base += "_"
+	}
+
+	if r.maxLength > 0 && len(base)+len(ext) > r.maxLength {
+		if len(ext) > r.maxLength/2 {
+			ext = ""
+		}
+
+		base = truncateUTF8(base, r.maxLength-len(ext))
+		if r.noTrailing {
+			base = strings.TrimRight(base, ". ")
+		}
+	}
+
+	name = base + ext
+	if r.validate(name) != nil {
+		return defaultName
+	}
+	return name
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,31 @@
+package trc
+
+import "syscall"
+
+// Magic numbers of file systems with stricter naming rules than POSIX, see statfs(2)
+const (
+	msdosSuperMagic = 0x4d44
+	exfatSuperMagic = 0x2011bab0
+	ntfsSuperMagic  = 0x5346544e
+	smbSuperMagic   = 0x517b
+	smb2SuperMagic  = 0xfe534d42
+	cifsSuperMagic  = 0xff534d42
+)
+
+// fileSystemNameProfile returns the name profile of the file system holding dir, as reported by
+// statfs. File systems not known to be stricter are assumed to follow POSIX rules.
+func fileSystemNameProfile(dir string) (NameProfile, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return NameProfileAuto, false
+	}
+
+	switch uint32(stat.Type) {
+	case msdosSuperMagic, exfatSuperMagic:
+		return NameProfileFAT, true
+	case ntfsSuperMagic, smbSuperMagic, smb2SuperMagic, cifsSuperMagic:
+		return NameProfileWindows, true
+	default:
+		return NameProfilePOSIX, true
+	}
+}
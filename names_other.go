@@ -0,0 +1,8 @@
+//go:build !linux
+
+package trc
+
+// fileSystemNameProfile cannot identify file systems on this platform, so the FAT rules are used.
+func fileSystemNameProfile(dir string) (NameProfile, bool) {
+	return NameProfileAuto, false
+}
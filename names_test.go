@@ -0,0 +1,257 @@
+package trc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFATNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Invalid character", "user@host.log", "user_host.log"},
+		{"Several invalid characters", "a:b*c?.txt", "a_b_c_.txt"},
+		{"Trailing dots and spaces", "notes. . ", "notes"},
+		{"Reserved name", "CON.txt", "CON_.txt"},
+		{"Reserved name without extension", "aux", "aux_"},
+		{"Nothing left", "...", defaultName},
+		{"Too long", strings.Repeat("a", 300) + ".txt", strings.Repeat("a", maxLength-4) + ".txt"},
+		{"Too long multibyte", strings.Repeat("é", 200), strings.Repeat("é", maxLength/2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := nameProfileRules[NameProfileFAT]
+			got := rules.sanitize(tt.input)
+			if got != tt.expected {
+				t.Errorf("sanitize(%q) = %q; want %q", tt.input, got, tt.expected)
+			}
+
+			if err := rules.validate(got); err != nil {
+				t.Errorf("sanitize(%q) = %q, which is invalid: %v", tt.input, got, err)
+			}
+		})
+	}
+}
+
+func TestInvalidNamePolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    InvalidNamePolicy
+		expectErr bool
+		links     []string
+	}{
+		{"Fail", InvalidNameFail, true, nil},
+		{"Skip", InvalidNameSkip, false, []string{"valid.txt"}},
+		{"Sanitize", InvalidNameSanitize, false, []string{"user_host.log", "valid.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir := t.TempDir()
+			for _, name := range []string{"user@host.log", "valid.txt"} {
+				if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("data"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var reported []InvalidName
+			outputDir := t.TempDir()
+			config := PartitionConfig{
+				SourceDir:         sourceDir,
+				OutputDirs:        []string{outputDir},
+				ByFile:            true,
+				NameProfile:       NameProfileFAT,
+				InvalidNamePolicy: tt.policy,
+				OnInvalidName:     func(n InvalidName) { reported = append(reported, n) },
+			}
+
+			plan, err := Plan(config)
+			if len(reported) != 1 || reported[0].Path != filepath.Join(sourceDir, "user@host.log") {
+				t.Errorf("expected user@host.log to be reported once, got %v", reported)
+			}
+
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("expected an error for an invalid name")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(plan.InvalidNames) != 1 {
+				t.Errorf("expected the plan to list 1 invalid name, got %v", plan.InvalidNames)
+			}
+
+			if err := plan.Apply(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, link := range tt.links {
+				target, err := os.Readlink(filepath.Join(outputDir, link))
+				if err != nil {
+					t.Fatalf("expected link %s: %v", link, err)
+				}
+
+				if filepath.Base(target) == link {
+					continue
+				}
+
+				if filepath.Base(target) != "user@host.log" {
+					t.Errorf("%s points to %s, want the original file", link, target)
+				}
+			}
+
+			if plan.TotalFiles() != len(tt.links) {
+				t.Errorf("expected %d links, got %d", len(tt.links), plan.TotalFiles())
+			}
+		})
+	}
+}
+
+func TestInvalidNamePolicyText(t *testing.T) {
+	for policy, name := range invalidNamePolicyNames {
+		parsed, err := ParseInvalidNamePolicy(strings.ToUpper(name))
+		if err != nil || parsed != policy {
+			t.Errorf("ParseInvalidNamePolicy(%q) = %v, %v; want %v", name, parsed, err, policy)
+		}
+	}
+
+	if _, err := ParseInvalidNamePolicy("rename"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
+
+func TestNameProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		valid    map[NameProfile]bool
+	}{
+		{"Plain name", "report.csv", map[NameProfile]bool{NameProfilePOSIX: true, NameProfileWindows: true, NameProfileFAT: true, NameProfileNone: true}},
+		{"At sign", "user@host.log", map[NameProfile]bool{NameProfilePOSIX: true, NameProfileWindows: true, NameProfileFAT: false, NameProfileNone: true}},
+		{"Colon", "12:00.txt", map[NameProfile]bool{NameProfilePOSIX: true, NameProfileWindows: false, NameProfileFAT: false, NameProfileNone: true}},
+		{"Trailing dot", "notes.", map[NameProfile]bool{NameProfilePOSIX: true, NameProfileWindows: false, NameProfileFAT: false, NameProfileNone: true}},
+		{"Device name", "nul.txt", map[NameProfile]bool{NameProfilePOSIX: true, NameProfileWindows: false, NameProfileFAT: false, NameProfileNone: true}},
+		{"NTFS metadata name", "$Mft", map[NameProfile]bool{NameProfilePOSIX: true, NameProfileWindows: true, NameProfileFAT: false, NameProfileNone: true}},
+		{"Too long", strings.Repeat("a", maxLength+1), map[NameProfile]bool{NameProfilePOSIX: false, NameProfileWindows: false, NameProfileFAT: false, NameProfileNone: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for profile, valid := range tt.valid {
+				rules := nameProfileRules[profile]
+				if err := rules.validate(tt.filename); (err == nil) != valid {
+					t.Errorf("%s: validate(%q) = %v; want valid = %v", profile, tt.filename, err, valid)
+				}
+
+				if sanitized := rules.sanitize(tt.filename); rules.validate(sanitized) != nil {
+					t.Errorf("%s: sanitize(%q) = %q, which is invalid", profile, tt.filename, sanitized)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveNameProfile(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "not", "created", "yet")
+
+	if got := resolveNameProfile(NameProfileWindows, []string{outputDir}); got != NameProfileWindows {
+		t.Errorf("an explicit profile resolved to %s", got)
+	}
+
+	if got := resolveNameProfile(NameProfileAuto, nil); got != NameProfileFAT {
+		t.Errorf("auto without output directories resolved to %s; want fat", got)
+	}
+
+	// Temporary directories live on POSIX file systems, but only Linux can tell
+	want := NameProfileFAT
+	if runtime.GOOS == "linux" {
+		want = NameProfilePOSIX
+	}
+
+	if got := resolveNameProfile(NameProfileAuto, []string{outputDir}); got != want {
+		t.Errorf("auto resolved to %s; want %s", got, want)
+	}
+
+	for profile, name := range nameProfileNames {
+		if parsed, err := ParseNameProfile(name); err != nil || parsed != profile {
+			t.Errorf("ParseNameProfile(%q) = %v, %v; want %v", name, parsed, err, profile)
+		}
+	}
+}
+
+func TestInvalidDirectoryNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    InvalidNamePolicy
+		expectErr bool
+		links     []string
+	}{
+		{"Fail", InvalidNameFail, true, nil},
+		{"Skip", InvalidNameSkip, false, []string{"ok/valid.txt"}},
+		{"Sanitize", InvalidNameSanitize, false, []string{"a_b/c/file.txt", "ok/valid.txt"}},
+	}
+
+	sourceDir := t.TempDir()
+	for _, path := range []string{"a:b/c./file.txt", "ok/valid.txt"} {
+		path = filepath.Join(sourceDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			config := PartitionConfig{
+				SourceDir:         sourceDir,
+				OutputDirs:        []string{outputDir},
+				ByFile:            true,
+				PreserveTree:      true,
+				NameProfile:       NameProfileWindows,
+				InvalidNamePolicy: tt.policy,
+			}
+
+			plan, err := Plan(config)
+			if tt.expectErr {
+				var invalid *InvalidNameError
+				if !errors.As(err, &invalid) || !strings.Contains(invalid.Error(), "directory a:b") {
+					t.Fatalf("expected an invalid name error for directory a:b, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var links []string
+			for _, link := range plan.Partitions[0].Links {
+				links = append(links, link.Link)
+			}
+
+			if !slices.Equal(links, tt.links) {
+				t.Errorf("expected links %v, got %v", tt.links, links)
+			}
+
+			if err := plan.Apply(); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+		})
+	}
+}
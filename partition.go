@@ -1,73 +1,229 @@
 package trc
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"time"
 )
 
 // PartitionConfig holds the configuration for partitioning files
 type PartitionConfig struct {
-	SourceDir  string   // Original directory
-	OutputDirs []string // Partition directories
-	BySize     bool     // Set to true to activate partition by size (largest -> smallest)
-	ByFile     bool     // Partition by MIME type
+	SourceDir     string   `json:"source_dir"`     // Original directory
+	OutputDirs    []string `json:"output_dirs"`    // Partition directories
+	Strategy      string   `json:"strategy"`       // Name of a registered Strategy; leave empty to use the flags below
+	BySize        bool     `json:"by_size"`        // Set to true to activate partition by size (largest -> smallest)
+	ByFile        bool     `json:"by_file"`        // Partition by file count
+	ByHash        bool     `json:"by_hash"`        // Partition by consistent hashing of the relative paths
+	PreserveTree  bool     `json:"preserve_tree"`  // Recreate each file's path relative to SourceDir inside its partition
+	LinkMode      LinkMode `json:"link_mode"`      // How files are placed in partitions: symlink (default), hardlink, reflink, copy or move
+	RelativeLinks bool     `json:"relative_links"` // Point symlinks at their source relative to the link instead of by absolute path
+
+	Include []string `json:"include,omitempty"` // Doublestar globs matched against paths relative to SourceDir; only matching files are partitioned
+	Exclude []string `json:"exclude,omitempty"` // Doublestar globs of files and directories to leave out, even if they match Include
+
+	UseGitignore   bool `json:"use_gitignore"`   // Honor .gitignore files in the source tree as well as .trcignore files
+	FollowSymlinks bool `json:"follow_symlinks"` // Walk symlinked directories of the source tree and link every file to its real path
+	WalkWorkers    int  `json:"walk_workers"`    // Directories read concurrently while walking the source tree; 0 uses GOMAXPROCS
+	LinkWorkers    int  `json:"link_workers"`    // Links created concurrently by Apply; 0 uses GOMAXPROCS
+
+	Stream          bool   `json:"stream"`              // Link files while walking instead of planning first, see MakePartitions
+	SpillDir        string `json:"spill_dir,omitempty"` // Directory for the temporary files of large runs; empty uses os.TempDir()
+	Atomic          bool   `json:"atomic"`              // Build the partitions in staging directories and swap them in once all succeeded, see Apply
+	ContinueOnError bool   `json:"continue_on_error"`   // Skip the files that fail instead of stopping the run, and return all their errors at the end
+
+	MinSize        int64     `json:"min_size,omitempty"`       // Only partition files of at least this many bytes
+	MaxSize        int64     `json:"max_size,omitempty"`       // Only partition files of at most this many bytes; 0 means no limit
+	ModifiedAfter  time.Time `json:"modified_after,omitzero"`  // Only partition files modified after this time, unless zero
+	ModifiedBefore time.Time `json:"modified_before,omitzero"` // Only partition files modified before this time, unless zero
+	FileKind       FileKind  `json:"file_kind"`                // Partition regular files and symlinks (default), or regular files only
+
+	CollisionPolicy CollisionPolicy `json:"collision_policy"` // What to do when two files map to the same link in a partition
+	OnCollision     func(Collision) `json:"-"`                // Called for every collision, whatever the policy
+
+	NameProfile       NameProfile       `json:"name_profile"`        // Rules link names must follow; auto picks those of the output directories' file system
+	InvalidNamePolicy InvalidNamePolicy `json:"invalid_name_policy"` // What to do with files whose name breaks the NameProfile rules
+	OnInvalidName     func(InvalidName) `json:"-"`                   // Called for every invalid name, whatever the policy
+
+	OnProgress func(Progress) `json:"-"` // Called as each phase of a run starts, processes a file and ends; calls never overlap
+	Logger     *slog.Logger   `json:"-"` // Receives skipped files, collisions, links and slow operations; nil logs nothing
+
+	Force bool `json:"-"` // Let RemovePartitionsWithConfig remove symlinks from directories trc did not create
 }
 
 // MakePartitions partitions the files in the source directory according to the configuration.
+// It is equivalent to calling Plan followed by Apply.
+//
+// With Stream set the files are linked while the source tree is walked instead, without holding
+// a plan in memory, which suits trees of millions of files. Only the count, hash and size
+// strategies can stream, and they produce the same partitions either way; the size strategy
+// sorts the files through temporary files in SpillDir before linking any of them.
+//
+// By default the first file that fails stops the run. With ContinueOnError a file that cannot be
+// read, named, placed or linked is left out instead, and the errors of every such file are
+// returned joined once all the others were linked. Each of them can be told apart with errors.As:
+// *InvalidNameError, *CollisionError, *LinkError, or an *fs.PathError for a file or directory of
+// the source tree that could not be read.
 func MakePartitions(config PartitionConfig) error {
+	return MakePartitionsContext(context.Background(), config)
+}
+
+// MakePartitionsContext is MakePartitions with a context. Once ctx is cancelled no further link
+// is created and the run is rolled back: the links and directories it created are removed and
+// the symlinks it replaced are restored, leaving the partitions as they were before. The
+// returned error then wraps the error of ctx.
+func MakePartitionsContext(ctx context.Context, config PartitionConfig) error {
+	if config.Stream {
+		return streamPartitions(ctx, config)
+	}
+
+	// With ContinueOnError the files that could be planned are linked even if others failed
+	plan, err := PlanContext(ctx, config)
+	if plan == nil {
+		return err
+	}
+
+	return errors.Join(err, plan.ApplyContext(ctx))
+}
+
+// Plan collects the files in the source directory and assigns them to partitions with the
+// configured strategy, without creating anything on disk. Call Apply on the result to create the
+// links. Files are partitioned by MIME type unless Strategy, ByFile, BySize or ByHash selects
+// another strategy; selecting more than one is an error. The source directory is resolved to an
+// absolute path first, so the links work from any directory. With ContinueOnError the files
+// that could not be planned are left out, and their errors are returned along with the plan.
+func Plan(config PartitionConfig) (*PartitionPlan, error) {
+	return PlanContext(context.Background(), config)
+}
+
+// PlanContext is Plan with a context, which stops walking the source tree once ctx is cancelled.
+func PlanContext(ctx context.Context, config PartitionConfig) (*PartitionPlan, error) {
 	if len(config.OutputDirs) == 0 {
-		return errors.New("at least one output directory is required")
+		return nil, errors.New("at least one output directory is required")
 	}
 
-	partitionFn, err := getPartitionFunction(config.ByFile, config.BySize)
+	config, err := withAbsSourceDir(config)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	strategy, err := resolveStrategy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record every invalid name in the plan before handing it to the caller's callback
+	var invalidNames []InvalidName
+	collectConfig := config
+	collectConfig.OnInvalidName = func(invalid InvalidName) {
+		invalidNames = append(invalidNames, invalid)
+		if config.OnInvalidName != nil {
+			config.OnInvalidName(invalid)
+		}
+	}
+
+	failures := newFailureList(config)
+	files, err := collectFileMeta(ctx, collectConfig, strategy, failures)
+	if err != nil {
+		return nil, err
 	}
 
-	return partitionFn(config.SourceDir, config.OutputDirs)
+	assignments, err := strategy.Assign(files, config.OutputDirs)
+	if err != nil {
+		return nil, fmt.Errorf("%s strategy failed: %w", strategy.Name(), err)
+	}
+
+	plan, err := planAssignments(assignments, config, strategy.Name(), failures)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.InvalidNames = invalidNames
+	return plan, failures.err()
 }
 
-// getPartitionFunction returns the appropriate partition function based on the flags.
-func getPartitionFunction(byFile, bySize bool) (func(string, []string) error, error) {
-	switch {
-	case byFile:
-		return partitionByFile, nil
-	case bySize:
-		return partitionBySize, nil
-	default:
-		return partitionByType, nil
+// workerCount returns the configured number of workers, GOMAXPROCS unless it is positive.
+func workerCount(configured int) int {
+	if configured > 0 {
+		return configured
 	}
+	return runtime.GOMAXPROCS(0)
 }
 
-// RemovePartitions removes the partition directories and their symlinks.
+// withAbsSourceDir returns the configuration with SourceDir made absolute, so every link records
+// an absolute source whatever the working directory was.
+func withAbsSourceDir(config PartitionConfig) (PartitionConfig, error) {
+	if config.SourceDir == "" {
+		return config, nil
+	}
+
+	sourceDir, err := filepath.Abs(config.SourceDir)
+	if err != nil {
+		return config, fmt.Errorf("failed to resolve source directory %s: %w", config.SourceDir, err)
+	}
+
+	config.SourceDir = sourceDir
+	return config, nil
+}
+
+// RemovePartitions removes the links trc created in outputDirs and prunes the directories that
+// removing them left empty. It is RemovePartitionsWithConfig with only the output directories set,
+// so directories without a manifest are refused.
 func RemovePartitions(outputDirs []string) error {
-	if err := removeSymlinkTree(outputDirs); err != nil {
-		return fmt.Errorf("failed to remove symlink tree: %w", err)
+	return RemovePartitionsWithConfig(PartitionConfig{OutputDirs: outputDirs})
+}
+
+// RemovePartitionsWithConfig removes the links trc created in config.OutputDirs and prunes the
+// directories that removing them left empty. Only symlinks listed in a partition manifest or
+// pointing into the recorded source tree (or config.SourceDir) are removed; regular files are never
+// touched. Directories without a manifest are refused with ErrNotPartition unless config.Force is
+// set, in which case the symlinks inside them pointing into config.SourceDir are removed, or every
+// symlink if no source directory is given.
+func RemovePartitionsWithConfig(config PartitionConfig) error {
+	return RemovePartitionsContext(context.Background(), config)
+}
+
+// RemovePartitionsContext is RemovePartitionsWithConfig with a context. Once ctx is cancelled nothing more
+// is removed and the run is rolled back: the links, directories and manifests it removed are put
+// back. The returned error then wraps the error of ctx.
+func RemovePartitionsContext(ctx context.Context, config PartitionConfig) error {
+	if len(config.OutputDirs) == 0 {
+		return errors.New("at least one output directory is required")
 	}
 
-	for _, dir := range outputDirs {
-		if err := os.RemoveAll(dir); err != nil {
-			return fmt.Errorf("failed to remove partition directory %s: %w", dir, err)
+	changes := newJournal(config.SpillDir)
+	failures := newFailureList(config)
+	var err error
+	for _, dir := range config.OutputDirs {
+		// With ContinueOnError a partition that cannot be removed does not keep the others
+		if err = failures.skip(removePartition(ctx, dir, config, changes)); err != nil {
+			break
+		}
+
+		if err = ctx.Err(); err != nil {
+			break
 		}
 	}
 
-	return nil
+	return changes.finish(ctx, errors.Join(err, failures.err()))
 }
 
-// partitionFiles splits a list of file paths into equal-sized groups.
-func partitionFiles(files []string, partitions int) [][]string {
+// partitionFiles splits a list of files into equal-sized groups.
+func partitionFiles[T any](files []T, partitions int) [][]T {
 	if partitions <= 0 || len(files) == 0 {
 		return nil
 	}
 
-	result := make([][]string, partitions)
+	result := make([][]T, partitions)
 	avgSize := (len(files) + partitions - 1) / partitions
 
 	// Preallocate capacity to avoid reallocations
 	for i := range result {
-		result[i] = make([]string, 0, avgSize)
+		result[i] = make([]T, 0, avgSize)
 	}
 
 	// Distribute files across partitions
@@ -84,8 +240,8 @@ func partitionFilesBySize(files []fileInfo, partitions int) [][]fileInfo {
 		return nil
 	}
 
-	// Sort files by size (largest first)
-	sort.Slice(files, func(i, j int) bool {
+	// Sort files by size (largest first), files of the same size keep their order
+	sort.SliceStable(files, func(i, j int) bool {
 		return files[i].size > files[j].size
 	})
 
@@ -112,58 +268,3 @@ func findMinPartitionIndex(sizes []int64) int {
 	}
 	return minIndex
 }
-
-// partitionByFile partitions files by their MIME type.
-func partitionByFile(sourceDir string, outputDirs []string) error {
-	files, err := collectFiles(sourceDir)
-	if err != nil {
-		return fmt.Errorf("failed to collect files from %s: %w", sourceDir, err)
-	}
-
-	partitions := partitionFiles(files, len(outputDirs))
-	if err := createSymlinkTree(partitions, outputDirs); err != nil {
-		return fmt.Errorf("failed to create symlink tree: %w", err)
-	}
-
-	return nil
-}
-
-// partitionBySize partitions files based on their size, attempting to balance partition sizes.
-func partitionBySize(sourceDir string, outputDirs []string) error {
-	files, err := collectFilesWithSize(sourceDir)
-	if err != nil {
-		return fmt.Errorf("failed to collect files with size from %s: %w", sourceDir, err)
-	}
-
-	partitions := partitionFilesBySize(files, len(outputDirs))
-	if err := createSymlinkTreeBySize(partitions, outputDirs); err != nil {
-		return fmt.Errorf("failed to create symlink tree by size: %w", err)
-	}
-
-	return nil
-}
-
-// partitionByType partitions files by their MIME type using round-robin distribution.
-func partitionByType(sourceDir string, destDirs []string) error {
-	mimeMap, err := collectFilesWithMimeType(sourceDir)
-	if err != nil {
-		return err
-	}
-
-	if len(destDirs) == 0 {
-		return errors.New("no destination directories provided")
-	}
-
-	// Round-robin distribution of files across directories
-	i := 0
-	for category, files := range mimeMap {
-		destDir := destDirs[i%len(destDirs)]
-		if err := createSymlinkWithMimeType(map[string][]string{category: files}, destDir); err != nil {
-			return err
-		}
-
-		i++
-	}
-
-	return nil
-}
@@ -1,6 +1,7 @@
 package trc
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -111,7 +112,7 @@ func TestMakePartitions(t *testing.T) {
 	// Ensure files were partitioned
 	counts := make(map[string]int)
 	for _, d := range outputDirs {
-		files, err := collectFilesWithMimeType(d)
+		files, err := collectFilesWithMimeType(context.Background(), d, nil)
 		if err != nil {
 			t.Fatalf("Failed to collect files from partition: %v", err)
 		}
@@ -133,11 +134,11 @@ func TestMakePartitions(t *testing.T) {
 
 func TestPartitionFilesBySize(t *testing.T) {
 	files := []fileInfo{
-		{"a.txt", 100},
-		{"b.txt", 200},
-		{"c.txt", 300},
-		{"d.txt", 400},
-		{"e.txt", 500},
+		{path: "a.txt", size: 100},
+		{path: "b.txt", size: 200},
+		{path: "c.txt", size: 300},
+		{path: "d.txt", size: 400},
+		{path: "e.txt", size: 500},
 	}
 
 	partitions := 2
@@ -170,3 +171,79 @@ func abs(n int64) int64 {
 
 	return n
 }
+
+func TestMakePartitionsPreserveTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PartitionConfig
+		subDir func(rel string) string // expected location of a link relative to its partition
+	}{
+		{
+			name:   "by count",
+			config: PartitionConfig{ByFile: true, PreserveTree: true},
+			subDir: func(rel string) string { return rel },
+		},
+		{
+			name:   "by size",
+			config: PartitionConfig{BySize: true, PreserveTree: true},
+			subDir: func(rel string) string { return rel },
+		},
+		{
+			name:   "by type",
+			config: PartitionConfig{PreserveTree: true},
+			subDir: func(rel string) string { return filepath.Join("text", rel) },
+		},
+	}
+
+	files := []string{
+		filepath.Join("a", "2024", "report.csv"),
+		filepath.Join("b", "2023", "report.csv"),
+		"top.txt",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+
+			for _, file := range files {
+				path := filepath.Join(sourceDir, file)
+				if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+					t.Fatalf("error creating directory: %v", err)
+				}
+
+				if err := os.WriteFile(path, []byte("some plain text content"), 0644); err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+			}
+
+			config := tt.config
+			config.SourceDir = sourceDir
+			config.OutputDirs = []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2")}
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("Partitioning failed: %v", err)
+			}
+
+			for _, file := range files {
+				found := 0
+				for _, dir := range config.OutputDirs {
+					linkPath := filepath.Join(dir, tt.subDir(file))
+					target, err := os.Readlink(linkPath)
+					if err != nil {
+						continue
+					}
+
+					if target != filepath.Join(sourceDir, file) {
+						t.Errorf("link %s points to %s, expected %s", linkPath, target, filepath.Join(sourceDir, file))
+					}
+					found++
+				}
+
+				if found != 1 {
+					t.Errorf("expected exactly one link for %s, found %d", file, found)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+)
+
+// PartitionPlan describes which file goes to which partition, as computed by Plan. Nothing is
+// created on disk until Apply is called, so a plan can be reviewed first.
+type PartitionPlan struct {
+	Config     PartitionConfig    `json:"config"`               // Configuration the plan was made with
+	Strategy   string             `json:"strategy"`             // Name of the partitioning strategy, such as count, size, mime or hash
+	Partitions []PlannedPartition `json:"partitions"`           // One entry per output directory, in order
+	Collisions []Collision        `json:"collisions,omitempty"` // Collisions resolved while planning
+
+	InvalidNames []InvalidName `json:"invalid_names,omitempty"` // Files skipped or renamed because of their name
+
+	validate bool // Set on plans read from a file, which are validated again before being applied
+}
+
+// PlannedPartition lists the links planned inside a single output directory.
+type PlannedPartition struct {
+	Dir   string        `json:"dir"`   // Output directory of the partition
+	Links []PlannedLink `json:"links"` // Links to create, in creation order
+}
+
+// PlannedLink is a single link to be created.
+type PlannedLink struct {
+	Source string `json:"source"` // File the link points to
+	Link   string `json:"link"`   // Path of the link relative to the partition directory, using forward slashes
+	Size   int64  `json:"size"`   // Size of the source when the plan was made
+}
+
+// LinkPath returns the full path of a link planned inside the partition.
+func (p PlannedPartition) LinkPath(link PlannedLink) string {
+	return filepath.Join(p.Dir, filepath.FromSlash(link.Link))
+}
+
+// TotalSize returns the combined size of the files planned for the partition.
+func (p PlannedPartition) TotalSize() int64 {
+	var total int64
+	for _, link := range p.Links {
+		total += link.Size
+	}
+	return total
+}
+
+// TotalFiles returns the number of links in the plan across all partitions.
+func (p *PartitionPlan) TotalFiles() int {
+	total := 0
+	for _, partition := range p.Partitions {
+		total += len(partition.Links)
+	}
+	return total
+}
+
+// Apply creates the links of the plan with the configured LinkMode and writes the manifest of
+// every partition. Links are created by up to LinkWorkers workers at once, and the manifests
+// list every link created even when some failed. Collisions were already resolved while
+// planning, so an existing symlink at a planned path is replaced, while anything else in the
+// way is reported as an error. Plans read from a file are checked with Validate first, and
+// nothing is created if they no longer match the source tree.
+//
+// Every link that fails is reported as a *LinkError. No further link is started after the first
+// failure, unless ContinueOnError is set: then every link is attempted and all the errors are
+// returned joined.
+//
+// With Atomic set, every partition is built in a hidden staging directory next to its output
+// directory, seeded with hard links to what the output directory holds, and the staging
+// directories replace the output directories one by one only once all of them were built, so a
+// failed run leaves the output directories untouched. On Linux each partition is exchanged with
+// its staging directory in one step; elsewhere, and on file systems that cannot exchange, the
+// output directory is missing for a moment between two renames. Atomic cannot be combined with
+// ContinueOnError, which keeps the links that succeeded.
+func (p *PartitionPlan) Apply() error {
+	return p.ApplyContext(context.Background())
+}
+
+// ApplyContext is Apply with a context. Once ctx is cancelled no further link is created and
+// everything the run changed is rolled back, like MakePartitionsContext does.
+func (p *PartitionPlan) ApplyContext(ctx context.Context) error {
+	changes := newJournal(p.Config.SpillDir)
+	return changes.finish(ctx, p.apply(ctx, changes))
+}
+
+// apply creates the links of the plan, recording every change in changes.
+func (p *PartitionPlan) apply(ctx context.Context, changes *journal) error {
+	if len(p.Partitions) != len(p.Config.OutputDirs) {
+		return fmt.Errorf("plan has %d partitions but %d output directories", len(p.Partitions), len(p.Config.OutputDirs))
+	}
+
+	if p.validate {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("plan no longer matches the source tree: %w", err)
+		}
+	}
+
+	if p.Config.Atomic {
+		if p.Config.ContinueOnError {
+			return errors.New("atomic runs cannot continue on error, they leave the partitions untouched if any link fails")
+		}
+		return p.applyStaged(ctx, changes)
+	}
+
+	run, err := newLinkRun(ctx, p.Config, p.Strategy, changes)
+	if err != nil {
+		return err
+	}
+
+	if err := createDirs(p.Partitions, changes); err != nil {
+		return err
+	}
+
+	return run.linkAll(p.Partitions)
+}
+
+// createDirs creates every directory the partitions and their links need, once each rather
+// than once per link.
+func createDirs(partitions []PlannedPartition, changes *journal) error {
+	dirs := make(map[string]bool)
+	for _, partition := range partitions {
+		dirs[partition.Dir] = true
+		for _, link := range partition.Links {
+			dirs[filepath.Dir(partition.LinkPath(link))] = true
+		}
+	}
+
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		if err := changes.mkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planner builds a PartitionPlan one file at a time, resolving link paths and collisions.
+type planner struct {
+	plan     *PartitionPlan
+	resolver *collisionResolver
+	index    []map[string]int // link path -> position in Links, one map per partition
+	failures *failureList     // Files left out of the plan with ContinueOnError
+}
+
+func newPlanner(config PartitionConfig, strategy string) *planner {
+	plan := &PartitionPlan{
+		Config:     config,
+		Strategy:   strategy,
+		Partitions: make([]PlannedPartition, len(config.OutputDirs)),
+	}
+
+	index := make([]map[string]int, len(config.OutputDirs))
+	for i, dir := range config.OutputDirs {
+		plan.Partitions[i].Dir = dir
+		index[i] = make(map[string]int)
+	}
+
+	// Record every collision in the plan before handing it to the caller's callback
+	resolverConfig := config
+	resolverConfig.OnCollision = func(c Collision) {
+		plan.Collisions = append(plan.Collisions, c)
+		if config.OnCollision != nil {
+			config.OnCollision(c)
+		}
+	}
+
+	return &planner{plan: plan, resolver: newCollisionResolver(resolverConfig), index: index}
+}
+
+// add plans a link to file inside dir, which is the partition directory or one of its subdirectories.
+func (p *planner) add(partition int, dir string, file fileInfo) error {
+	linkPath, link, err := placeLink(p.plan.Config, p.resolver, partition, dir, file)
+	if err != nil {
+		return p.failures.skip(err)
+	}
+
+	if linkPath == "" {
+		return nil
+	}
+
+	planned := &p.plan.Partitions[partition]
+
+	// An overwritten link takes the place of the one planned earlier
+	if i, ok := p.index[partition][linkPath]; ok {
+		planned.Links[i] = link
+		return nil
+	}
+
+	p.index[partition][linkPath] = len(planned.Links)
+	planned.Links = append(planned.Links, link)
+	return nil
+}
+
+// addAssignment plans the link for an assignment returned by a strategy.
+func (p *planner) addAssignment(assignment Assignment) error {
+	dir, err := assignmentDir(p.plan.Config, assignment)
+	if err != nil {
+		return err
+	}
+
+	return p.add(assignment.Partition, dir, newFileInfo(assignment.File))
+}
+
+// assignmentDir returns the directory an assignment places its file in, checking that it stays
+// inside one of the partitions.
+func assignmentDir(config PartitionConfig, assignment Assignment) (string, error) {
+	if assignment.Partition >= len(config.OutputDirs) {
+		return "", fmt.Errorf("%s was assigned to partition %d, but there are only %d", assignment.File.Path, assignment.Partition, len(config.OutputDirs))
+	}
+
+	group := filepath.FromSlash(assignment.Group)
+	if group != "" && !filepath.IsLocal(group) {
+		return "", fmt.Errorf("%s was assigned to group %q, which is outside of its partition", assignment.File.Path, assignment.Group)
+	}
+
+	return filepath.Join(config.OutputDirs[assignment.Partition], group), nil
+}
+
+// placeLink decides where the link to file goes inside dir, which is the partition directory or
+// one of its subdirectories, and resolves collisions. The link is placed according to the path
+// of the file and points to its target, if it has one. An empty link path means the file is
+// skipped.
+func placeLink(config PartitionConfig, resolver *collisionResolver, partition int, dir string, file fileInfo) (string, PlannedLink, error) {
+	linkPath, err := resolveLinkPath(config, dir, file.path)
+	if err != nil {
+		return "", PlannedLink{}, err
+	}
+
+	// A sanitized name replaces the whole path below the partition when the tree is preserved,
+	// its directories may have been sanitized too
+	switch {
+	case file.name != "" && config.PreserveTree:
+		linkPath = filepath.Join(dir, filepath.FromSlash(file.name))
+	case file.name != "":
+		linkPath = filepath.Join(filepath.Dir(linkPath), file.name)
+	}
+
+	source := file.path
+	if file.target != "" {
+		source = file.target
+	}
+
+	linkPath, err = resolver.resolve(partition, linkPath, source)
+	if err != nil || linkPath == "" {
+		return "", PlannedLink{}, err
+	}
+
+	relPath, err := filepath.Rel(config.OutputDirs[partition], linkPath)
+	if err != nil {
+		return "", PlannedLink{}, fmt.Errorf("failed to resolve %s relative to %s: %w", linkPath, config.OutputDirs[partition], err)
+	}
+
+	return linkPath, PlannedLink{Source: source, Link: filepath.ToSlash(relPath), Size: file.size}, nil
+}
+
+// planPartitions plans links for files already split into one group per output directory.
+func planPartitions(files [][]fileInfo, config PartitionConfig, strategy string) (*PartitionPlan, error) {
+	planner := newPlanner(config, strategy)
+	for i, partition := range files {
+		for _, file := range partition {
+			if err := planner.add(i, config.OutputDirs[i], file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return planner.plan, nil
+}
@@ -0,0 +1,185 @@
+package trc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanDoesNotTouchFilesystem(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PartitionConfig
+	}{
+		{name: "by count", config: PartitionConfig{ByFile: true}},
+		{name: "by size", config: PartitionConfig{BySize: true}},
+		{name: "by type", config: PartitionConfig{}},
+		{name: "by hash", config: PartitionConfig{ByHash: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+
+			sizes := map[string]int{"a.txt": 100, "b.txt": 200, "c.txt": 300, "d.txt": 400}
+			for name, size := range sizes {
+				content := make([]byte, size)
+				for i := range content {
+					content[i] = 'x'
+				}
+
+				if err := os.WriteFile(filepath.Join(sourceDir, name), content, 0644); err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+			}
+
+			config := tt.config
+			config.SourceDir = sourceDir
+			config.OutputDirs = []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2")}
+
+			plan, err := Plan(config)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+
+			for _, dir := range config.OutputDirs {
+				if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("Plan created %s", dir)
+				}
+			}
+
+			if plan.TotalFiles() != len(sizes) {
+				t.Errorf("expected %d planned files, got %d", len(sizes), plan.TotalFiles())
+			}
+
+			var totalSize int64
+			for _, partition := range plan.Partitions {
+				totalSize += partition.TotalSize()
+			}
+
+			if totalSize != 1000 {
+				t.Errorf("expected planned total size 1000, got %d", totalSize)
+			}
+
+			if err := plan.Apply(); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+
+			for _, partition := range plan.Partitions {
+				for _, link := range partition.Links {
+					target, err := os.Readlink(partition.LinkPath(link))
+					if err != nil {
+						t.Errorf("planned link %s was not created: %v", link.Link, err)
+					} else if target != link.Source {
+						t.Errorf("link %s points to %s, expected %s", link.Link, target, link.Source)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRefusesToReplaceRegularFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "file.txt")
+	if err := os.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	outputDir := filepath.Join(tempDir, "partition1")
+	plan, err := planPartitions([][]fileInfo{{{path: source, size: 7}}}, PartitionConfig{OutputDirs: []string{outputDir}}, strategyCount)
+	if err != nil {
+		t.Fatalf("planPartitions failed: %v", err)
+	}
+
+	// Something appears at the planned path after the plan was made
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(outputDir, "file.txt"), []byte("precious"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	if err := plan.Apply(); err == nil {
+		t.Fatalf("expected Apply to refuse replacing a regular file")
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "file.txt"))
+	if err != nil || string(content) != "precious" {
+		t.Errorf("regular file was modified: %q, %v", content, err)
+	}
+}
+
+func TestApplyWithLinkWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		blocked string // Planned link replaced by a regular file before Apply
+	}{
+		{"One worker", 1, ""},
+		{"Many workers", 8, ""},
+		{"Failure with one worker", 1, "file05.txt"},
+		{"Failure with many workers", 8, "file05.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			for i := range 20 {
+				path := filepath.Join(sourceDir, fmt.Sprintf("sub%d", i%4), fmt.Sprintf("file%02d.txt", i))
+				if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			outputDir := filepath.Join(tempDir, "partition1")
+			plan, err := Plan(PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true, PreserveTree: true, LinkWorkers: tt.workers})
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+
+			if tt.blocked != "" {
+				if err := os.MkdirAll(filepath.Join(outputDir, "sub1"), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(filepath.Join(outputDir, "sub1", tt.blocked), []byte("precious"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = plan.Apply()
+			if (err != nil) != (tt.blocked != "") {
+				t.Fatalf("Apply returned %v", err)
+			}
+
+			// The manifest lists exactly the links that were created, even after a failure
+			manifest, err := ReadManifest(outputDir)
+			if err != nil {
+				t.Fatalf("ReadManifest failed: %v", err)
+			}
+
+			for _, link := range manifest.Links {
+				if _, err := os.Readlink(filepath.Join(outputDir, filepath.FromSlash(link.Path))); err != nil {
+					t.Errorf("manifest lists %s, which is not a link: %v", link.Path, err)
+				}
+			}
+
+			if tt.blocked == "" && len(manifest.Links) != 20 {
+				t.Errorf("expected 20 links in the manifest, got %d", len(manifest.Links))
+			}
+		})
+	}
+}
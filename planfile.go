@@ -0,0 +1,221 @@
+package trc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Header of plans written by WriteCSV. Partition indexes start at 0, and a row with an empty
+// source only declares a partition that has no links.
+var planCSVHeader = []string{"partition", "dir", "source", "link", "size"}
+
+// WriteJSON writes the plan as indented JSON.
+func (p *PartitionPlan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(p); err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes the plan as CSV, one row per file with the index of its partition.
+func (p *PartitionPlan) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(planCSVHeader); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+
+	for i, partition := range p.Partitions {
+		index := strconv.Itoa(i)
+		if len(partition.Links) == 0 {
+			if err := writer.Write([]string{index, partition.Dir, "", "", ""}); err != nil {
+				return fmt.Errorf("failed to write plan: %w", err)
+			}
+		}
+
+		for _, link := range partition.Links {
+			row := []string{index, partition.Dir, link.Source, link.Link, strconv.FormatInt(link.Size, 10)}
+			if err := writer.Write(row); err != nil {
+				return fmt.Errorf("failed to write plan: %w", err)
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// ReadPlanJSON reads a plan written by WriteJSON. Applying it first validates the plan.
+func ReadPlanJSON(r io.Reader) (*PartitionPlan, error) {
+	var plan PartitionPlan
+	if err := json.NewDecoder(r).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+
+	for i, partition := range plan.Partitions {
+		if partition.Dir == "" {
+			return nil, fmt.Errorf("partition %d has no directory", i)
+		}
+	}
+
+	return loadedPlan(&plan), nil
+}
+
+// ReadPlanCSV reads a plan written by WriteCSV. Only the assignment of files to partitions is
+// stored in CSV, so the configuration of the returned plan is limited to its output directories.
+// Applying it first validates the plan.
+func ReadPlanCSV(r io.Reader) (*PartitionPlan, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(planCSVHeader)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan header: %w", err)
+	}
+
+	if strings.Join(header, ",") != strings.Join(planCSVHeader, ",") {
+		return nil, fmt.Errorf("unexpected plan header %q, expected %q", strings.Join(header, ","), strings.Join(planCSVHeader, ","))
+	}
+
+	// Partitions are collected by index and must number 0 to n-1 once every row is read, so a
+	// huge index is an error rather than a huge slice
+	partitions := make(map[int]*PlannedPartition)
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plan: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		index, err := strconv.Atoi(row[0])
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("line %d: invalid partition index %q", line, row[0])
+		}
+
+		if row[1] == "" {
+			return nil, fmt.Errorf("line %d: partition %d has no directory", line, index)
+		}
+
+		partition, ok := partitions[index]
+		if !ok {
+			partition = &PlannedPartition{Dir: row[1]}
+			partitions[index] = partition
+		} else if partition.Dir != row[1] {
+			return nil, fmt.Errorf("line %d: partition %d is both %s and %s", line, index, partition.Dir, row[1])
+		}
+
+		if row[2] == "" {
+			continue
+		}
+
+		size, err := strconv.ParseInt(row[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid size %q", line, row[4])
+		}
+
+		partition.Links = append(partition.Links, PlannedLink{Source: row[2], Link: row[3], Size: size})
+	}
+
+	plan := &PartitionPlan{Partitions: make([]PlannedPartition, len(partitions))}
+	for i := range plan.Partitions {
+		partition, ok := partitions[i]
+		if !ok {
+			return nil, fmt.Errorf("partition %d is missing, the plan has %d partitions", i, len(partitions))
+		}
+		plan.Partitions[i] = *partition
+	}
+
+	return loadedPlan(plan), nil
+}
+
+// LoadPlan reads a plan from a file, as CSV if the name ends in .csv and as JSON otherwise.
+func LoadPlan(name string) (*PartitionPlan, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plan: %w", err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return ReadPlanCSV(f)
+	}
+	return ReadPlanJSON(f)
+}
+
+// Save writes the plan to a file, as CSV if the name ends in .csv and as JSON otherwise.
+func (p *PartitionPlan) Save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		err = p.WriteCSV(f)
+	} else {
+		err = p.WriteJSON(f)
+	}
+
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write plan file: %w", closeErr)
+	}
+	return err
+}
+
+// Validate checks that every link stays inside its partition, that no link is listed twice, and
+// that every source still exists with the size recorded in the plan. All problems are reported
+// together.
+func (p *PartitionPlan) Validate() error {
+	var errs []error
+	for i, partition := range p.Partitions {
+		seen := make(map[string]bool, len(partition.Links))
+		for _, link := range partition.Links {
+			clean := path.Clean(link.Link)
+			if link.Link == "" || path.IsAbs(clean) || filepath.IsAbs(filepath.FromSlash(clean)) || clean == ".." || strings.HasPrefix(clean, "../") {
+				errs = append(errs, fmt.Errorf("partition %d: link %q is outside of %s", i, link.Link, partition.Dir))
+				continue
+			}
+
+			if seen[clean] {
+				errs = append(errs, fmt.Errorf("partition %d: link %q is listed more than once", i, link.Link))
+			}
+			seen[clean] = true
+
+			info, err := os.Stat(link.Source)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("partition %d: source of %q: %w", i, link.Link, err))
+			} else if info.Size() != link.Size {
+				errs = append(errs, fmt.Errorf("partition %d: source %s is %d bytes, plan recorded %d", i, link.Source, info.Size(), link.Size))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadedPlan prepares a plan read from a file for Apply: the output directories are taken from
+// the partitions, and the plan is validated again before any link is created.
+func loadedPlan(plan *PartitionPlan) *PartitionPlan {
+	plan.Config.OutputDirs = make([]string, len(plan.Partitions))
+	for i, partition := range plan.Partitions {
+		plan.Config.OutputDirs[i] = partition.Dir
+	}
+
+	plan.validate = true
+	return plan
+}
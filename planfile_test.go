@@ -0,0 +1,185 @@
+package trc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.MkdirAll(filepath.Join(sourceDir, "sub"), os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	for i, name := range []string{"a.txt", "b.txt", filepath.Join("sub", "c,d.txt")} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), make([]byte, (i+1)*10), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+
+	config := PartitionConfig{
+		SourceDir:    sourceDir,
+		OutputDirs:   []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2"), filepath.Join(tempDir, "partition3"), filepath.Join(tempDir, "partition4")},
+		BySize:       true,
+		PreserveTree: true,
+	}
+
+	plan, err := Plan(config)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		write func(*PartitionPlan, *bytes.Buffer) error
+		read  func(*bytes.Buffer) (*PartitionPlan, error)
+	}{
+		{
+			name:  "json",
+			write: func(p *PartitionPlan, b *bytes.Buffer) error { return p.WriteJSON(b) },
+			read:  func(b *bytes.Buffer) (*PartitionPlan, error) { return ReadPlanJSON(b) },
+		},
+		{
+			name:  "csv",
+			write: func(p *PartitionPlan, b *bytes.Buffer) error { return p.WriteCSV(b) },
+			read:  func(b *bytes.Buffer) (*PartitionPlan, error) { return ReadPlanCSV(b) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(plan, &buf); err != nil {
+				t.Fatalf("failed to write plan: %v", err)
+			}
+
+			loaded, err := tt.read(&buf)
+			if err != nil {
+				t.Fatalf("failed to read plan: %v", err)
+			}
+
+			if !reflect.DeepEqual(loaded.Partitions, plan.Partitions) {
+				t.Errorf("partitions changed in round trip:\n got %+v\nwant %+v", loaded.Partitions, plan.Partitions)
+			}
+
+			if !reflect.DeepEqual(loaded.Config.OutputDirs, config.OutputDirs) {
+				t.Errorf("output dirs changed in round trip: %v", loaded.Config.OutputDirs)
+			}
+
+			if err := loaded.Validate(); err != nil {
+				t.Errorf("expected loaded plan to be valid, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplySavedPlanRevalidates(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "file.txt")
+	if err := os.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	outputDir := filepath.Join(tempDir, "partition1")
+	plan, err := planPartitions([][]fileInfo{{{path: source, size: 7}}}, PartitionConfig{OutputDirs: []string{outputDir}}, strategyCount)
+	if err != nil {
+		t.Fatalf("planPartitions failed: %v", err)
+	}
+
+	planFile := filepath.Join(tempDir, "plan.json")
+	if err := plan.Save(planFile); err != nil {
+		t.Fatalf("failed to save plan: %v", err)
+	}
+
+	// The source changes between saving and replaying the plan
+	if err := os.WriteFile(source, []byte("changed content"), 0644); err != nil {
+		t.Fatalf("error updating file: %v", err)
+	}
+
+	loaded, err := LoadPlan(planFile)
+	if err != nil {
+		t.Fatalf("failed to load plan: %v", err)
+	}
+
+	if err := loaded.Apply(); err == nil {
+		t.Fatalf("expected Apply to reject a plan whose source changed")
+	}
+
+	if _, err := os.Lstat(filepath.Join(outputDir, "file.txt")); !os.IsNotExist(err) {
+		t.Errorf("no link should be created when validation fails, got: %v", err)
+	}
+}
+
+func TestValidatePlan(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "file.txt")
+	if err := os.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		links    []PlannedLink
+		errorMsg string
+	}{
+		{"valid", []PlannedLink{{Source: source, Link: "file.txt", Size: 7}}, ""},
+		{"missing source", []PlannedLink{{Source: source + ".gone", Link: "file.txt", Size: 7}}, "no such file"},
+		{"size changed", []PlannedLink{{Source: source, Link: "file.txt", Size: 8}}, "plan recorded 8"},
+		{"escapes partition", []PlannedLink{{Source: source, Link: "../file.txt", Size: 7}}, "outside of"},
+		{"absolute link", []PlannedLink{{Source: source, Link: "/etc/file.txt", Size: 7}}, "outside of"},
+		{"duplicate link", []PlannedLink{{Source: source, Link: "file.txt", Size: 7}, {Source: source, Link: "./file.txt", Size: 7}}, "more than once"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &PartitionPlan{Partitions: []PlannedPartition{{Dir: filepath.Join(tempDir, "partition1"), Links: tt.links}}}
+
+			err := plan.Validate()
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Errorf("expected no error, got: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("expected error containing %q, got: %v", tt.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestReadInvalidPlan(t *testing.T) {
+	tests := []struct {
+		name string
+		read func(string) (*PartitionPlan, error)
+		plan string
+	}{
+		{"csv huge index", readPlanCSVString, "partition,dir,source,link,size\n2000000000,out,,,\n"},
+		{"csv missing partition", readPlanCSVString, "partition,dir,source,link,size\n0,out1,,,\n2,out3,,,\n"},
+		{"csv empty dir", readPlanCSVString, "partition,dir,source,link,size\n0,,,,\n"},
+		{"csv conflicting dirs", readPlanCSVString, "partition,dir,source,link,size\n0,out1,,,\n0,out2,,,\n"},
+		{"json empty dir", readPlanJSONString, `{"partitions": [{"dir": "out1"}, {"dir": ""}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plan, err := tt.read(tt.plan); err == nil {
+				t.Errorf("expected the plan to be rejected, got %+v", plan.Partitions)
+			}
+		})
+	}
+}
+
+func readPlanCSVString(plan string) (*PartitionPlan, error) {
+	return ReadPlanCSV(strings.NewReader(plan))
+}
+
+func readPlanJSONString(plan string) (*PartitionPlan, error) {
+	return ReadPlanJSON(strings.NewReader(plan))
+}
@@ -0,0 +1,105 @@
+package trc
+
+import "sync"
+
+// Phase is a stage of a run, as reported by PartitionConfig.OnProgress.
+type Phase int
+
+const (
+	PhaseWalk   Phase = iota // Walking the source tree
+	PhaseDetect              // Walking the source tree and detecting MIME types
+	PhasePlan                // Assigning files to partitions and resolving link paths
+	PhaseLink                // Creating links
+)
+
+var phaseNames = map[Phase]string{
+	PhaseWalk:   "walk",
+	PhaseDetect: "detect",
+	PhasePlan:   "plan",
+	PhaseLink:   "link",
+}
+
+// String returns the name of the phase.
+func (p Phase) String() string {
+	return enumString(phaseNames, "Phase", p)
+}
+
+func (p Phase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// Progress describes how far a phase of a run got. An event is reported when a phase starts,
+// after every file it processed, and once more with Done set when it is over.
+type Progress struct {
+	Phase      Phase `json:"phase"`
+	Files      int   `json:"files"`       // Files processed so far in this phase
+	Bytes      int64 `json:"bytes"`       // Total size of those files
+	TotalFiles int   `json:"total_files"` // Files the phase will process, 0 if it is not known in advance
+	TotalBytes int64 `json:"total_bytes"` // Total size of those files, 0 if it is not known in advance
+	Partition  int   `json:"partition"`   // Partition of the last file processed, -1 if there is none
+	Done       bool  `json:"done"`        // Set on the last event of the phase
+}
+
+// progressReporter reports the progress of the phases of one run. Events are reported one at a
+// time, even when they come from several workers or phases at once, so OnProgress needs no
+// locking of its own. A nil reporter reports nothing.
+type progressReporter struct {
+	mu sync.Mutex
+	fn func(Progress)
+}
+
+func newProgressReporter(fn func(Progress)) *progressReporter {
+	if fn == nil {
+		return nil
+	}
+	return &progressReporter{fn: fn}
+}
+
+// phaseProgress counts the files processed by one phase.
+type phaseProgress struct {
+	reporter *progressReporter
+	state    Progress
+}
+
+// start reports the start of a phase and returns the counter of its files. Totals are 0 when
+// they are not known in advance.
+func (r *progressReporter) start(phase Phase, totalFiles int, totalBytes int64) *phaseProgress {
+	if r == nil {
+		return nil
+	}
+
+	p := &phaseProgress{reporter: r, state: Progress{Phase: phase, TotalFiles: totalFiles, TotalBytes: totalBytes, Partition: -1}}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fn(p.state)
+	return p
+}
+
+// add reports a file of size bytes processed for the given partition, -1 if there is none.
+func (p *phaseProgress) add(partition int, size int64) {
+	if p == nil {
+		return
+	}
+
+	p.reporter.mu.Lock()
+	defer p.reporter.mu.Unlock()
+
+	p.state.Files++
+	p.state.Bytes += size
+	p.state.Partition = partition
+	p.reporter.fn(p.state)
+}
+
+// done reports the end of the phase.
+func (p *phaseProgress) done() {
+	if p == nil {
+		return
+	}
+
+	p.reporter.mu.Lock()
+	defer p.reporter.mu.Unlock()
+
+	p.state.Done = true
+	p.reporter.fn(p.state)
+}
@@ -0,0 +1,108 @@
+package trc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PartitionConfig
+		phases []Phase // Phases in the order they start
+	}{
+		{"Count", PartitionConfig{ByFile: true}, []Phase{PhaseWalk, PhasePlan, PhaseLink}},
+		{"Size", PartitionConfig{BySize: true}, []Phase{PhaseWalk, PhasePlan, PhaseLink}},
+		{"Type", PartitionConfig{}, []Phase{PhaseDetect, PhasePlan, PhaseLink}},
+		{"Atomic", PartitionConfig{ByHash: true, Atomic: true}, []Phase{PhaseWalk, PhasePlan, PhaseLink}},
+		{"Stream", PartitionConfig{ByHash: true, Stream: true}, []Phase{PhaseLink, PhaseWalk}},
+	}
+
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	const files = 30
+	var totalBytes int64
+	for i := range files {
+		path := filepath.Join(sourceDir, fmt.Sprintf("sub%d", i%3), fmt.Sprintf("file%02d.txt", i))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		content := strings.Repeat("x", i+1)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		totalBytes += int64(len(content))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				events  []Progress
+				running atomic.Bool
+			)
+
+			config := tt.config
+			config.SourceDir = sourceDir
+			config.OutputDirs = []string{filepath.Join(t.TempDir(), "partition1"), filepath.Join(t.TempDir(), "partition2")}
+			config.SpillDir = t.TempDir()
+			config.OnProgress = func(event Progress) {
+				if running.Swap(true) {
+					t.Error("OnProgress was called while another call was running")
+				}
+				events = append(events, event)
+				running.Store(false)
+			}
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("MakePartitions failed: %v", err)
+			}
+
+			byPhase := make(map[Phase][]Progress)
+			var started []Phase
+			for _, event := range events {
+				if len(byPhase[event.Phase]) == 0 {
+					started = append(started, event.Phase)
+				}
+				byPhase[event.Phase] = append(byPhase[event.Phase], event)
+			}
+
+			if !slices.Equal(started, tt.phases) {
+				t.Fatalf("expected phases %v, got %v", tt.phases, started)
+			}
+
+			for phase, events := range byPhase {
+				first, last := events[0], events[len(events)-1]
+				if first.Files != 0 || first.Done || first.Partition != -1 {
+					t.Errorf("%s: expected the phase to start with nothing processed, got %+v", phase, first)
+				}
+
+				if !last.Done || last.Files != files || last.Bytes != totalBytes {
+					t.Errorf("%s: expected the phase to end done with %d files of %d bytes, got %+v", phase, files, totalBytes, last)
+				}
+
+				// Totals are known once the source tree was walked, unless the files are linked while walking
+				known := phase != PhaseWalk && phase != PhaseDetect && !config.Stream
+				if known != (last.TotalFiles == files && last.TotalBytes == totalBytes) {
+					t.Errorf("%s: unexpected totals %d files of %d bytes", phase, last.TotalFiles, last.TotalBytes)
+				}
+
+				for i, event := range events[1 : len(events)-1] {
+					if event.Files != i+1 || event.Done {
+						t.Errorf("%s: expected event %d to count %d files, got %+v", phase, i+1, i+1, event)
+					}
+
+					walking := phase == PhaseWalk || phase == PhaseDetect
+					if walking != (event.Partition == -1) || event.Partition >= len(config.OutputDirs) {
+						t.Errorf("%s: unexpected partition in %+v", phase, event)
+					}
+				}
+			}
+		})
+	}
+}
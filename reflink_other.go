@@ -0,0 +1,13 @@
+//go:build !linux
+
+package trc
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is only implemented on Linux, elsewhere reflinks fall back to a plain copy.
+func cloneFile(in, out *os.File) error {
+	return errors.New("cloning files is not supported on this platform")
+}
@@ -0,0 +1,211 @@
+package trc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// changeOp is the kind of change a journal records.
+type changeOp int
+
+const (
+	changeMkdir    changeOp = iota // Directory created
+	changeLink                     // Link created, replacing the symlink in Target if it is set
+	changeUnlink                   // Link removed: a symlink to Target, or a file placed with Mode
+	changeRmdir                    // Empty directory removed
+	changeManifest                 // Manifest replaced or removed, its previous content is in Data
+	changeStaging                  // Staging directory created, removed with everything left in it
+)
+
+// change is a single change made to the partitions, with what it takes to undo it.
+type change struct {
+	Seq    int64        `json:"seq"`
+	Op     changeOp     `json:"op"`
+	Path   string       `json:"path"`
+	Mode   LinkMode     `json:"mode"`
+	Link   ManifestLink `json:"link"`
+	Target string       `json:"target,omitempty"` // Raw target of a symlink that was replaced or removed
+	Data   []byte       `json:"data,omitempty"`
+}
+
+// journal records the changes a run makes to the partitions, so that a cancelled run can be
+// rolled back and leave them as they were before it started. Changes go through a spillSorter,
+// newest first, so the journal of a very large run does not have to fit in memory. A nil
+// journal records nothing.
+type journal struct {
+	mu      sync.Mutex // Changes are recorded by several workers at once
+	seq     int64
+	changes *spillSorter[change]
+}
+
+func newJournal(spillDir string) *journal {
+	newestFirst := func(a, b change) int { return cmp.Compare(b.Seq, a.Seq) }
+	return &journal{changes: newSpillSorter(spillDir, newestFirst)}
+}
+
+func (j *journal) record(c change) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+	c.Seq = j.seq
+	return j.changes.add(c)
+}
+
+// mkdirAll creates dir and its missing parents, recording each directory it created.
+func (j *journal) mkdirAll(dir string) error {
+	var missing []string
+	for parent := dir; ; parent = filepath.Dir(parent) {
+		if _, err := os.Lstat(parent); err == nil || parent == filepath.Dir(parent) {
+			break
+		}
+		missing = append(missing, parent)
+	}
+
+	if err := ensureDirectory(dir); err != nil {
+		return err
+	}
+
+	// Record parents first, so they are removed after their children
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := j.record(change{Op: changeMkdir, Path: missing[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSymlink removes the symlink at path, recording where it pointed.
+func (j *journal) removeSymlink(path string) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %s: %w", path, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove symlink %s: %w", path, err)
+	}
+
+	return j.record(change{Op: changeUnlink, Path: path, Target: target})
+}
+
+// saveManifest records the current manifest of dir before it is replaced or removed.
+func (j *journal) saveManifest(dir string) error {
+	if j == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(ManifestPath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of %s: %w", dir, err)
+	}
+
+	return j.record(change{Op: changeManifest, Path: dir, Data: data})
+}
+
+// finish ends the run that returned err. A run that failed because ctx was cancelled is rolled
+// back; any other outcome is kept as is, including the links of a run that failed on its own.
+func (j *journal) finish(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return errors.Join(err, j.close())
+	}
+
+	if rollbackErr := j.rollback(); rollbackErr != nil {
+		return fmt.Errorf("run cancelled, rolling back failed: %w", errors.Join(ctx.Err(), rollbackErr))
+	}
+	return fmt.Errorf("run cancelled and rolled back: %w", ctx.Err())
+}
+
+// rollback undoes every recorded change, newest first. A change that cannot be undone does not
+// stop the others; every failure is returned.
+func (j *journal) rollback() error {
+	defer j.close()
+
+	var errs []error
+	err := j.changes.each(func(c change) error {
+		if err := c.undo(); err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+
+	return errors.Join(append(errs, err)...)
+}
+
+// close removes the temporary files of the journal.
+func (j *journal) close() error {
+	return j.changes.close()
+}
+
+func (c change) undo() error {
+	switch c.Op {
+	case changeMkdir:
+		if err := os.Remove(c.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove directory %s: %w", c.Path, err)
+		}
+
+	case changeLink:
+		linker, err := NewLinker(c.Mode)
+		if err != nil {
+			return err
+		}
+
+		if err := linker.Remove(c.Path, c.Link); err != nil {
+			return err
+		}
+
+		if c.Target != "" {
+			if err := os.Symlink(c.Target, c.Path); err != nil {
+				return fmt.Errorf("failed to restore symlink %s: %w", c.Path, err)
+			}
+		}
+
+	case changeUnlink:
+		if c.Target != "" {
+			if err := os.Symlink(c.Target, c.Path); err != nil {
+				return fmt.Errorf("failed to restore symlink %s: %w", c.Path, err)
+			}
+			return nil
+		}
+
+		linker, err := NewLinker(c.Mode)
+		if err != nil {
+			return err
+		}
+		return linker.Link(c.Link.Target, c.Path)
+
+	case changeRmdir:
+		if err := os.Mkdir(c.Path, os.ModePerm); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to restore directory %s: %w", c.Path, err)
+		}
+
+	case changeStaging:
+		if err := os.RemoveAll(c.Path); err != nil {
+			return fmt.Errorf("failed to remove staging directory %s: %w", c.Path, err)
+		}
+
+	case changeManifest:
+		if err := ensureDirectory(filepath.Join(c.Path, ManifestDir)); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(ManifestPath(c.Path), c.Data, 0644); err != nil {
+			return fmt.Errorf("failed to restore manifest of %s: %w", c.Path, err)
+		}
+	}
+
+	return nil
+}
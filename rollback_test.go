@@ -0,0 +1,209 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// cancelAfter is a context that reports itself cancelled once Err was called more than n times,
+// which cancels a run at a deterministic point.
+type cancelAfter struct {
+	context.Context
+	n     int64
+	calls atomic.Int64
+}
+
+func newCancelAfter(n int64) *cancelAfter {
+	return &cancelAfter{Context: context.Background(), n: n}
+}
+
+func (c *cancelAfter) Err() error {
+	if c.calls.Add(1) > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+// snapshotTree describes every entry below root: directories, symlink targets and file contents.
+func snapshotTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	snapshot := make(map[string]string)
+	if _, err := os.Lstat(root); errors.Is(err, os.ErrNotExist) {
+		return snapshot
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			snapshot[path] = "dir"
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			snapshot[path] = "-> " + target
+		default:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			snapshot[path] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to snapshot %s: %v", root, err)
+	}
+	return snapshot
+}
+
+func TestCancelRollsBack(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   LinkMode
+		stream bool
+		before bool // Partition the source once before the cancelled run
+		run    func(ctx context.Context, config PartitionConfig) error
+	}{
+		{"Make", LinkSymlink, false, false, MakePartitionsContext},
+		{"Make over existing partitions", LinkSymlink, false, true, MakePartitionsContext},
+		{"Make with copies", LinkCopy, false, true, MakePartitionsContext},
+		{"Make with moves", LinkMove, false, false, MakePartitionsContext},
+		{"Stream", LinkSymlink, true, true, MakePartitionsContext},
+		{"Make atomically", LinkMove, false, true, func(ctx context.Context, config PartitionConfig) error {
+			config.Atomic = true
+			return MakePartitionsContext(ctx, config)
+		}},
+		{"Remove", LinkSymlink, false, true, RemovePartitionsContext},
+		{"Remove copies", LinkCopy, false, true, RemovePartitionsContext},
+		{"Remove moved files", LinkMove, false, true, RemovePartitionsContext},
+		{"Sync", LinkSymlink, false, true, func(ctx context.Context, config PartitionConfig) error {
+			_, err := SyncPartitionsContext(ctx, config)
+			return err
+		}},
+	}
+
+	// setup creates the source tree, and the partitions if the test asks for them, inside tempDir
+	setup := func(t *testing.T, tempDir string, mode LinkMode, before bool) PartitionConfig {
+		t.Helper()
+
+		sourceDir := filepath.Join(tempDir, "source")
+		for i := range 20 {
+			path := filepath.Join(sourceDir, fmt.Sprintf("sub%d", i%3), fmt.Sprintf("file%02d.txt", i))
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(path, []byte(fmt.Sprintf("content %d", i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		config := PartitionConfig{
+			SourceDir:    sourceDir,
+			OutputDirs:   []string{filepath.Join(tempDir, "out", "partition1"), filepath.Join(tempDir, "out", "partition2")},
+			ByFile:       true,
+			PreserveTree: true,
+			LinkMode:     mode,
+			SpillDir:     t.TempDir(),
+		}
+
+		if before {
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("MakePartitions failed: %v", err)
+			}
+
+			// Give the cancelled run something to add and something to take away
+			if err := os.WriteFile(filepath.Join(sourceDir, "sub1", "new.txt"), []byte("new"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if mode == LinkSymlink {
+				if err := os.Remove(filepath.Join(sourceDir, "sub0", "file00.txt")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		return config
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Count how often a complete run checks its context, to cancel it halfway and at the very end
+			counter := newCancelAfter(math.MaxInt64)
+			config := setup(t, t.TempDir(), tt.mode, tt.before)
+			config.Stream = tt.stream
+			if err := tt.run(counter, config); err != nil {
+				t.Fatalf("uncancelled run failed: %v", err)
+			}
+			checks := counter.calls.Load()
+
+			for _, cancel := range []int64{checks / 2, checks - 2} {
+				tempDir := t.TempDir()
+				config := setup(t, tempDir, tt.mode, tt.before)
+				outputs := snapshotTree(t, filepath.Join(tempDir, "out"))
+				sources := snapshotTree(t, config.SourceDir)
+
+				config.Stream = tt.stream
+				err := tt.run(newCancelAfter(cancel), config)
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected the run to be cancelled after %d of %d checks, got %v", cancel, checks, err)
+				}
+
+				if got := snapshotTree(t, filepath.Join(tempDir, "out")); !maps.Equal(got, outputs) {
+					t.Errorf("partitions were not rolled back after %d of %d checks:\nbefore %v\nafter  %v", cancel, checks, outputs, got)
+				}
+
+				if got := snapshotTree(t, config.SourceDir); !maps.Equal(got, sources) {
+					t.Errorf("source tree was not rolled back after %d of %d checks:\nbefore %v\nafter  %v", cancel, checks, sources, got)
+				}
+
+				if left, _ := filepath.Glob(filepath.Join(config.SpillDir, "*")); len(left) > 0 {
+					t.Errorf("expected no temporary files to be left, found %v", left)
+				}
+			}
+		})
+	}
+}
+
+func TestUncancelledRunIsKept(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancelling after the run is over changes nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	outputDir := filepath.Join(tempDir, "partition1")
+	if err := MakePartitionsContext(ctx, PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true}); err != nil {
+		t.Fatalf("MakePartitionsContext failed: %v", err)
+	}
+	cancel()
+
+	if _, err := os.Readlink(filepath.Join(outputDir, "file.txt")); err != nil {
+		t.Errorf("expected the link to be kept: %v", err)
+	}
+
+	if _, err := ReadManifest(outputDir); err != nil {
+		t.Errorf("expected the manifest to be kept: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package trc
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// spillChunkSize is the number of records a spillSorter keeps in memory before writing them to
+// a temporary file.
+const spillChunkSize = 1 << 15
+
+// spillSorter sorts more records than fit in memory. Records are buffered up to a fixed number,
+// then sorted and written to a temporary file as JSON lines, and each merges the files back in
+// order. Records that compare equal keep the order they were added in. Nothing is written to
+// disk as long as the records fit in a single chunk.
+type spillSorter[T any] struct {
+	dir    string // Where temporary files go, os.TempDir() if empty
+	limit  int
+	cmp    func(a, b T) int
+	buffer []T
+	files  []string
+}
+
+func newSpillSorter[T any](dir string, cmp func(a, b T) int) *spillSorter[T] {
+	return &spillSorter[T]{dir: dir, limit: spillChunkSize, cmp: cmp}
+}
+
+// add adds a record, spilling the buffered records to disk once the chunk is full.
+func (s *spillSorter[T]) add(record T) error {
+	s.buffer = append(s.buffer, record)
+	if len(s.buffer) < s.limit {
+		return nil
+	}
+	return s.spill()
+}
+
+func (s *spillSorter[T]) spill() error {
+	slices.SortStableFunc(s.buffer, s.cmp)
+
+	file, err := os.CreateTemp(s.dir, "trc-spill-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	s.files = append(s.files, file.Name())
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, record := range s.buffer {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write spill file %s: %w", file.Name(), err)
+		}
+	}
+
+	if err := errors.Join(w.Flush(), file.Close()); err != nil {
+		return fmt.Errorf("failed to write spill file %s: %w", file.Name(), err)
+	}
+
+	s.buffer = s.buffer[:0]
+	return nil
+}
+
+// each calls fn for every record added so far, in sorted order.
+func (s *spillSorter[T]) each(fn func(T) error) error {
+	if len(s.files) == 0 {
+		slices.SortStableFunc(s.buffer, s.cmp)
+		for _, record := range s.buffer {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.buffer) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	merge := &spillMerge[T]{cmp: s.cmp}
+	defer merge.close()
+
+	for i, name := range s.files {
+		file, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to read spill file: %w", err)
+		}
+
+		source := &spillSource[T]{index: i, file: file, decoder: json.NewDecoder(bufio.NewReader(file))}
+		merge.sources = append(merge.sources, source)
+		if ok, err := source.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Push(merge, source)
+		}
+	}
+
+	for merge.Len() > 0 {
+		source := merge.heads[0]
+		if err := fn(source.record); err != nil {
+			return err
+		}
+
+		ok, err := source.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(merge, 0)
+		} else {
+			heap.Pop(merge)
+		}
+	}
+
+	return nil
+}
+
+// close removes the temporary files.
+func (s *spillSorter[T]) close() error {
+	var errs []error
+	for _, name := range s.files {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	s.files = nil
+	s.buffer = nil
+	return errors.Join(errs...)
+}
+
+// spillSource reads the records of one spill file.
+type spillSource[T any] struct {
+	index   int // Order the file was written in, which breaks ties between equal records
+	file    *os.File
+	decoder *json.Decoder
+	record  T
+}
+
+func (s *spillSource[T]) next() (bool, error) {
+	var record T
+	if err := s.decoder.Decode(&record); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read spill file %s: %w", s.file.Name(), err)
+	}
+
+	s.record = record
+	return true, nil
+}
+
+// spillMerge is a heap of the spill files holding the next record of each.
+type spillMerge[T any] struct {
+	cmp     func(a, b T) int
+	sources []*spillSource[T]
+	heads   []*spillSource[T]
+}
+
+func (m *spillMerge[T]) Len() int { return len(m.heads) }
+
+func (m *spillMerge[T]) Less(i, j int) bool {
+	if c := m.cmp(m.heads[i].record, m.heads[j].record); c != 0 {
+		return c < 0
+	}
+	return m.heads[i].index < m.heads[j].index
+}
+
+func (m *spillMerge[T]) Swap(i, j int) { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+
+func (m *spillMerge[T]) Push(x any) { m.heads = append(m.heads, x.(*spillSource[T])) }
+
+func (m *spillMerge[T]) Pop() any {
+	last := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return last
+}
+
+func (m *spillMerge[T]) close() {
+	for _, source := range m.sources {
+		source.file.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package trc
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSpillSorter(t *testing.T) {
+	type record struct {
+		Key   int `json:"key"`
+		Order int `json:"order"`
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		count int
+		files int // Spill files expected before each is called
+	}{
+		{"In memory", 100, 50, 0},
+		{"One chunk", 10, 10, 1},
+		{"Several chunks", 7, 50, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sorter := newSpillSorter(dir, func(a, b record) int { return cmp.Compare(a.Key, b.Key) })
+			sorter.limit = tt.limit
+
+			var want []record
+			for i := range tt.count {
+				r := record{Key: (i * 7) % 5, Order: i}
+				want = append(want, r)
+				if err := sorter.add(r); err != nil {
+					t.Fatalf("add failed: %v", err)
+				}
+			}
+			slices.SortStableFunc(want, func(a, b record) int { return cmp.Compare(a.Key, b.Key) })
+
+			if len(sorter.files) != tt.files {
+				t.Errorf("expected %d spill files, got %d", tt.files, len(sorter.files))
+			}
+
+			var got []record
+			err := sorter.each(func(r record) error {
+				got = append(got, r)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("each failed: %v", err)
+			}
+
+			// Records with the same key keep the order they were added in
+			if !slices.Equal(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+
+			if err := sorter.close(); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+
+			if left, _ := filepath.Glob(filepath.Join(dir, "*")); len(left) > 0 {
+				t.Errorf("expected the spill files to be removed, found %v", left)
+			}
+
+			if _, err := os.Stat(dir); err != nil {
+				t.Errorf("expected the spill directory to be kept: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Suffixes of the hidden directories an atomic run keeps next to each output directory
+const (
+	stagingSuffix = ".trc-staging" // Partition being built
+	retiredSuffix = ".trc-retired" // Previous partition, while it is being swapped out
+)
+
+// siblingDir returns the hidden directory next to outputDir with the given suffix. It shares the
+// parent of outputDir, so it can be renamed into place and relative symlinks stay valid.
+func siblingDir(outputDir, suffix string) string {
+	return filepath.Join(filepath.Dir(outputDir), "."+filepath.Base(outputDir)+suffix)
+}
+
+// applyStaged creates the links of the plan in a staging directory next to every output
+// directory, seeded with what the output directory already holds, and only renames them into
+// place once every partition was built. If anything fails the output directories are left
+// untouched: the links created in staging are undone, which moves moved files back, and the
+// staging directories are removed.
+func (p *PartitionPlan) applyStaged(ctx context.Context, changes *journal) error {
+	dirs := make([]string, len(p.Config.OutputDirs))
+	staging := make([]string, len(p.Config.OutputDirs))
+	for i, outputDir := range p.Config.OutputDirs {
+		dir, err := stagedDir(outputDir)
+		if err != nil {
+			return err
+		}
+		dirs[i] = dir
+		staging[i] = siblingDir(dir, stagingSuffix)
+
+		// A directory left by a run that was killed may hold moved files, never remove it blindly
+		for _, leftover := range []string{staging[i], siblingDir(dir, retiredSuffix)} {
+			if _, err := os.Lstat(leftover); err == nil {
+				return fmt.Errorf("%s was left by an interrupted run, remove it once it holds nothing you need", leftover)
+			}
+		}
+	}
+
+	err := p.buildStaging(ctx, dirs, staging, changes)
+	if err == nil {
+		// Nothing is swapped once the run was cancelled
+		err = ctx.Err()
+	}
+
+	if err == nil {
+		err = swapPartitions(dirs, staging)
+	}
+
+	if err != nil {
+		return errors.Join(err, changes.rollback())
+	}
+
+	// The new partitions are in place, what is left cannot fail the run anymore
+	return removeRetired(dirs)
+}
+
+// stagedDir returns the directory an atomic run replaces for outputDir: outputDir itself, or the
+// directory it resolves to when it goes through symlinks, as output directories on other mounts
+// often do. Staging next to the symlink instead would seed the staging directory with a copy of
+// the symlink and link straight into the live directory.
+func stagedDir(outputDir string) (string, error) {
+	if _, err := os.Lstat(outputDir); errors.Is(err, os.ErrNotExist) {
+		return outputDir, nil
+	}
+
+	dir, err := filepath.EvalSymlinks(outputDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output directory %s: %w", outputDir, err)
+	}
+	return dir, nil
+}
+
+// buildStaging seeds the staging directories from the resolved output directories in dirs and
+// creates the links of the plan inside them. Every staging directory is recorded in changes, so
+// rolling back removes it.
+func (p *PartitionPlan) buildStaging(ctx context.Context, dirs, staging []string, changes *journal) error {
+	for i, dir := range dirs {
+		if err := changes.mkdirAll(filepath.Dir(dir)); err != nil {
+			return err
+		}
+
+		if err := seedStaging(dir, staging[i]); err != nil {
+			return errors.Join(err, os.RemoveAll(staging[i]))
+		}
+
+		if err := changes.record(change{Op: changeStaging, Path: staging[i]}); err != nil {
+			return errors.Join(err, os.RemoveAll(staging[i]))
+		}
+	}
+
+	partitions := slices.Clone(p.Partitions)
+	for i := range partitions {
+		partitions[i].Dir = staging[i]
+	}
+
+	if err := createDirs(partitions, changes); err != nil {
+		return err
+	}
+
+	run, err := newLinkRun(ctx, p.Config, p.Strategy, changes)
+	if err != nil {
+		return err
+	}
+	run.manifests.dirs = staging
+
+	return run.linkAll(partitions)
+}
+
+// seedStaging creates the staging directory of outputDir with a copy of its tree: directories
+// are recreated, symlinks copied and other files hard linked, or copied where hard links are not
+// possible. Nothing is read from outputDir if it does not exist yet.
+func seedStaging(outputDir, staging string) error {
+	if _, err := os.Lstat(outputDir); errors.Is(err, os.ErrNotExist) {
+		if err := os.Mkdir(staging, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create staging directory %s: %w", staging, err)
+		}
+		return nil
+	}
+
+	err := filepath.WalkDir(outputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(outputDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(staging, relPath)
+
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.Mkdir(dest, info.Mode().Perm())
+
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dest)
+
+		default:
+			if err := os.Link(path, dest); err == nil {
+				return nil
+			}
+			return copyFile(path, dest, false)
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %w", outputDir, err)
+	}
+	return nil
+}
+
+// swapPartitions puts every staging directory in place of its output directory, moving the
+// previous output directory aside. If a swap fails, the partitions already swapped are put back.
+func swapPartitions(outputDirs, staging []string) error {
+	var swapped []int
+	existed := make([]bool, len(outputDirs))
+
+	unswap := func() error {
+		var errs []error
+		for _, i := range slices.Backward(swapped) {
+			if !existed[i] {
+				errs = append(errs, os.Rename(outputDirs[i], staging[i]))
+				continue
+			}
+
+			_, err := replaceDir(siblingDir(outputDirs[i], retiredSuffix), outputDirs[i], staging[i])
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+
+	for i, dir := range outputDirs {
+		var err error
+		if existed[i], err = replaceDir(staging[i], dir, siblingDir(dir, retiredSuffix)); err != nil {
+			return errors.Join(fmt.Errorf("failed to swap partition %s: %w", dir, err), unswap())
+		}
+		swapped = append(swapped, i)
+	}
+
+	return nil
+}
+
+// replaceDir moves the directory src to dst and what was at dst to old, reporting whether dst
+// existed. Where exchangeDirs is supported dst never goes missing, otherwise it is renamed out of
+// the way first and does not exist until src takes its place. Nothing is moved if it fails.
+func replaceDir(src, dst, old string) (bool, error) {
+	err := exchangeDirs(src, dst)
+	switch {
+	case err == nil:
+		// src holds the previous dst now
+		if err := os.Rename(src, old); err != nil {
+			return false, errors.Join(err, exchangeDirs(src, dst))
+		}
+		return true, nil
+
+	case errors.Is(err, os.ErrNotExist):
+		return false, os.Rename(src, dst)
+
+	case !errors.Is(err, errors.ErrUnsupported):
+		return false, err
+	}
+
+	if err := os.Rename(dst, old); errors.Is(err, os.ErrNotExist) {
+		return false, os.Rename(src, dst)
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return false, errors.Join(err, os.Rename(old, dst))
+	}
+	return true, nil
+}
+
+// removeRetired removes the previous output directories once the new ones are in place, which is
+// safe since the staging directories held hard links to their files.
+func removeRetired(outputDirs []string) error {
+	var errs []error
+	for _, dir := range outputDirs {
+		if err := os.RemoveAll(siblingDir(dir, retiredSuffix)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove previous partition %s: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,302 @@
+package trc
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyAtomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    LinkMode
+		before  bool   // Partition the source once before the atomic run
+		blocked string // Planned link replaced by a regular file before Apply
+	}{
+		{"New partitions", LinkSymlink, false, ""},
+		{"Existing partitions", LinkSymlink, true, ""},
+		{"Existing partitions with hard links", LinkHardlink, true, ""},
+		{"Failure", LinkSymlink, true, "new.txt"},
+		{"Failure with moves", LinkMove, false, "file05.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			if err := os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range 10 {
+				if err := os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("file%02d.txt", i)), []byte(fmt.Sprintf("content %d", i)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			outputDirs := []string{filepath.Join(tempDir, "out", "partition1"), filepath.Join(tempDir, "out", "partition2")}
+			config := PartitionConfig{SourceDir: sourceDir, OutputDirs: outputDirs, ByFile: true, LinkMode: tt.mode}
+			if tt.before {
+				if err := MakePartitions(config); err != nil {
+					t.Fatalf("MakePartitions failed: %v", err)
+				}
+
+				// Files trc did not create are carried over as well
+				if err := os.WriteFile(filepath.Join(outputDirs[0], "notes.txt"), []byte("notes"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(filepath.Join(sourceDir, "new.txt"), []byte("new"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config.Atomic = true
+			plan, err := Plan(config)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+
+			for _, partition := range plan.Partitions {
+				for _, link := range partition.Links {
+					if link.Link != tt.blocked {
+						continue
+					}
+
+					if err := os.MkdirAll(partition.Dir, os.ModePerm); err != nil {
+						t.Fatal(err)
+					}
+
+					if err := os.WriteFile(partition.LinkPath(link), []byte("precious"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			outputs := snapshotTree(t, filepath.Join(tempDir, "out"))
+			sources := snapshotTree(t, sourceDir)
+
+			err = plan.Apply()
+			if (err != nil) != (tt.blocked != "") {
+				t.Fatalf("Apply returned %v", err)
+			}
+
+			// Nothing is left next to the partitions either way
+			if left, _ := filepath.Glob(filepath.Join(tempDir, "out", ".*")); len(left) > 0 {
+				t.Errorf("expected no staging directories to be left, found %v", left)
+			}
+
+			if tt.blocked != "" {
+				if got := snapshotTree(t, filepath.Join(tempDir, "out")); !maps.Equal(got, outputs) {
+					t.Errorf("a failed run changed the partitions:\nbefore %v\nafter  %v", outputs, got)
+				}
+
+				if got := snapshotTree(t, sourceDir); !maps.Equal(got, sources) {
+					t.Errorf("a failed run changed the source tree:\nbefore %v\nafter  %v", sources, got)
+				}
+				return
+			}
+
+			linker, err := NewLinker(tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			total := 0
+			for _, dir := range outputDirs {
+				manifest, err := ReadManifest(dir)
+				if err != nil {
+					t.Fatalf("ReadManifest failed: %v", err)
+				}
+
+				for _, link := range manifest.Links {
+					if err := linker.Verify(filepath.Join(dir, filepath.FromSlash(link.Path)), link); err != nil {
+						t.Errorf("%s does not match the manifest: %v", link.Path, err)
+					}
+				}
+				total += len(manifest.Links)
+			}
+
+			if want := len(snapshotTree(t, sourceDir)) - 1; total != want {
+				t.Errorf("expected %d links in the manifests, got %d", want, total)
+			}
+
+			if tt.before {
+				if data, err := os.ReadFile(filepath.Join(outputDirs[0], "notes.txt")); err != nil || string(data) != "notes" {
+					t.Errorf("expected notes.txt to be carried over, got %q, %v", data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAtomicRefusesLeftovers(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(tempDir, "partition1")
+	leftover := filepath.Join(siblingDir(outputDir, stagingSuffix), "moved.txt")
+	if err := os.MkdirAll(filepath.Dir(leftover), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(leftover, []byte("only copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := MakePartitions(PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true, Atomic: true})
+	if err == nil {
+		t.Fatal("expected the leftover staging directory to be refused")
+	}
+
+	if _, err := os.Stat(leftover); err != nil {
+		t.Errorf("expected the leftover to be kept: %v", err)
+	}
+
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("expected no partition to be created, got %v", err)
+	}
+}
+
+func TestApplyAtomicSymlinkedOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	writeSources := func(names ...string) {
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeSources("a.txt", "b.txt")
+
+	// The output directory is a symlink to the directory that really holds the partition
+	realDir := filepath.Join(tempDir, "mnt", "realout")
+	if err := os.MkdirAll(realDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(tempDir, "out")
+	if err := os.Symlink(realDir, outputDir); err != nil {
+		t.Fatal(err)
+	}
+
+	config := PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true}
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("MakePartitions failed: %v", err)
+	}
+
+	writeSources("c.txt", "d.txt", "e.txt")
+	config.Atomic = true
+
+	plan, err := Plan(config)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	// A failed run leaves the real directory untouched, as it was built in staging
+	blocked := filepath.Join(realDir, "d.txt")
+	if err := os.WriteFile(blocked, []byte("precious"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshotTree(t, realDir)
+
+	if err := plan.Apply(); err == nil {
+		t.Fatal("expected Apply to fail on the blocked link")
+	}
+
+	if got := snapshotTree(t, realDir); !maps.Equal(got, before) {
+		t.Errorf("a failed run changed the real directory:\nbefore %v\nafter  %v", before, got)
+	}
+
+	// A successful run swaps the real directory and keeps the symlink
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("MakePartitions failed: %v", err)
+	}
+
+	if info, err := os.Lstat(outputDir); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected the output directory to stay a symlink, got %v, %v", info, err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		if _, err := os.Readlink(filepath.Join(realDir, name)); err != nil {
+			t.Errorf("expected %s to be linked in the real directory: %v", name, err)
+		}
+	}
+
+	for _, dir := range []string{tempDir, filepath.Dir(realDir)} {
+		if left, _ := filepath.Glob(filepath.Join(dir, ".*")); len(left) > 0 {
+			t.Errorf("expected no staging directories to be left, found %v", left)
+		}
+	}
+}
+
+func TestReplaceDir(t *testing.T) {
+	for _, existing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("existing=%v", existing), func(t *testing.T) {
+			tempDir := t.TempDir()
+			src := filepath.Join(tempDir, "staging")
+			dst := filepath.Join(tempDir, "partition")
+			old := filepath.Join(tempDir, "retired")
+
+			if err := os.MkdirAll(src, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(src, "new.txt"), []byte("new"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if existing {
+				if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(filepath.Join(dst, "old.txt"), []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			existed, err := replaceDir(src, dst, old)
+			if err != nil {
+				t.Fatalf("replaceDir failed: %v", err)
+			}
+
+			if existed != existing {
+				t.Errorf("expected existed to be %v", existing)
+			}
+
+			if _, err := os.Stat(filepath.Join(dst, "new.txt")); err != nil {
+				t.Errorf("expected the staged file in place: %v", err)
+			}
+
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("expected the staging directory to be gone, got %v", err)
+			}
+
+			_, err = os.Stat(filepath.Join(old, "old.txt"))
+			if existing && err != nil {
+				t.Errorf("expected the previous partition to be moved aside: %v", err)
+			} else if !existing && !os.IsNotExist(err) {
+				t.Errorf("expected nothing to be moved aside, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Strategy decides which partition every file goes to. Register custom strategies with
+// RegisterStrategy and select them by name with PartitionConfig.Strategy.
+type Strategy interface {
+	// Name identifies the strategy in PartitionConfig.Strategy, plans and manifests.
+	Name() string
+
+	// Assign returns the partition of each file, as an index into outputDirs. Links are planned in
+	// the order of the returned assignments, and files left out of the result are not linked.
+	Assign(files []FileMeta, outputDirs []string) ([]Assignment, error)
+}
+
+// TypeAwareStrategy is implemented by strategies that need FileMeta.Type. Detecting the type
+// reads the start of every file, so it is only done for strategies that ask for it. Empty files
+// and files whose type cannot be detected are then left out.
+type TypeAwareStrategy interface {
+	Strategy
+	NeedsType() bool
+}
+
+// FileMeta describes a file collected from the source directory.
+type FileMeta struct {
+	Path    string // Path of the file, starting with PartitionConfig.SourceDir
+	RelPath string // Path relative to the source directory, using forward slashes
+	Size    int64  // Size in bytes
+	Type    string // MIME category such as "image" or "text", only set for a TypeAwareStrategy
+
+	// LinkName is the name the file is linked as when its own name is invalid and
+	// PartitionConfig.InvalidNamePolicy is InvalidNameSanitize, otherwise empty. With
+	// PartitionConfig.PreserveTree it is the whole link path below the partition, using forward
+	// slashes, as the names of directories are sanitized too.
+	LinkName string
+
+	// Target is the real file behind Path when Path goes through symlinks followed with
+	// PartitionConfig.FollowSymlinks, otherwise empty. Links point to Target when it is set.
+	Target string
+}
+
+// Assignment places a single file in a partition.
+type Assignment struct {
+	File      FileMeta // File to link
+	Partition int      // Index into the output directories; negative leaves the file out
+	Group     string   // Optional subdirectory of the partition to link the file in, using forward slashes
+}
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]Strategy{
+		strategyCount: countStrategy{},
+		strategySize:  sizeStrategy{},
+		strategyMime:  mimeStrategy{},
+		strategyHash:  hashStrategy{},
+	}
+)
+
+// RegisterStrategy makes a strategy available under its name. Registering a second strategy
+// with the same name, including the name of a built-in strategy, is an error.
+func RegisterStrategy(strategy Strategy) error {
+	if strategy == nil || strategy.Name() == "" {
+		return errors.New("strategy must have a name")
+	}
+
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	if _, exists := strategies[strategy.Name()]; exists {
+		return fmt.Errorf("strategy %q is already registered", strategy.Name())
+	}
+
+	strategies[strategy.Name()] = strategy
+	return nil
+}
+
+// LookupStrategy returns the strategy registered under name.
+func LookupStrategy(name string) (Strategy, bool) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	strategy, ok := strategies[name]
+	return strategy, ok
+}
+
+// Strategies returns the names of all registered strategies, sorted.
+func Strategies() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveStrategy returns the strategy selected by the configuration. Strategy, ByFile, BySize
+// and ByHash are alternatives, so selecting two different strategies is an error. Without any
+// selection files are partitioned by MIME type.
+func resolveStrategy(config PartitionConfig) (Strategy, error) {
+	var selected []string
+	for _, choice := range []struct {
+		set  bool
+		name string
+	}{
+		{config.Strategy != "", config.Strategy},
+		{config.ByFile, strategyCount},
+		{config.BySize, strategySize},
+		{config.ByHash, strategyHash},
+	} {
+		if choice.set && !slices.Contains(selected, choice.name) {
+			selected = append(selected, choice.name)
+		}
+	}
+
+	name := strategyMime
+	switch len(selected) {
+	case 0:
+	case 1:
+		name = selected[0]
+	default:
+		return nil, fmt.Errorf("conflicting partitioning strategies: %s", strings.Join(selected, ", "))
+	}
+
+	strategy, ok := LookupStrategy(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown partitioning strategy %q, available: %s", name, strings.Join(Strategies(), ", "))
+	}
+	return strategy, nil
+}
+
+// isBuiltinStrategy reports whether name is one of the strategies that ship with trc.
+func isBuiltinStrategy(name string) bool {
+	switch name {
+	case strategyCount, strategySize, strategyMime, strategyHash:
+		return true
+	}
+	return false
+}
+
+// needsType reports whether the strategy needs FileMeta.Type.
+func needsType(strategy Strategy) bool {
+	typeAware, ok := strategy.(TypeAwareStrategy)
+	return ok && typeAware.NeedsType()
+}
+
+// collectFileMeta collects the files of the source directory selected by the configuration for
+// the strategy. Files and directories that cannot be read are recorded in failures when it is
+// not nil.
+func collectFileMeta(ctx context.Context, config PartitionConfig, strategy Strategy, failures *failureList) ([]FileMeta, error) {
+	filter, err := newFileFilter(config)
+	if err != nil {
+		return nil, err
+	}
+	filter.failures = failures
+
+	sourceDir := config.SourceDir
+	var files []FileMeta
+
+	progress := newProgressReporter(config.OnProgress)
+	if needsType(strategy) {
+		filter.progress = progress.start(PhaseDetect, 0, 0)
+		mimeMap, err := collectFilesWithMimeType(ctx, sourceDir, filter)
+		if err != nil {
+			return nil, err
+		}
+		filter.progress.done()
+
+		for category, categoryFiles := range mimeMap {
+			for _, file := range categoryFiles {
+				meta, err := newFileMeta(sourceDir, file)
+				if err != nil {
+					return nil, err
+				}
+
+				meta.Type = category
+				files = append(files, meta)
+			}
+		}
+
+		return files, nil
+	}
+
+	filter.progress = progress.start(PhaseWalk, 0, 0)
+	collected, err := collectFilesWithSize(ctx, sourceDir, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect files from %s: %w", sourceDir, err)
+	}
+	filter.progress.done()
+
+	for _, file := range collected {
+		meta, err := newFileMeta(sourceDir, file)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, meta)
+	}
+
+	return files, nil
+}
+
+func newFileMeta(sourceDir string, file fileInfo) (FileMeta, error) {
+	relPath, err := filepath.Rel(sourceDir, file.path)
+	if err != nil {
+		return FileMeta{}, fmt.Errorf("failed to resolve %s relative to %s: %w", file.path, sourceDir, err)
+	}
+
+	return FileMeta{Path: file.path, RelPath: filepath.ToSlash(relPath), Size: file.size, LinkName: file.name, Target: file.target}, nil
+}
+
+// planAssignments plans the links for the assignments returned by a strategy. Files whose link
+// cannot be planned are recorded in failures when it is not nil.
+func planAssignments(assignments []Assignment, config PartitionConfig, strategy string, failures *failureList) (*PartitionPlan, error) {
+	var totalFiles int
+	var totalBytes int64
+	for _, assignment := range assignments {
+		if assignment.Partition >= 0 {
+			totalFiles++
+			totalBytes += assignment.File.Size
+		}
+	}
+
+	planner := newPlanner(config, strategy)
+	planner.failures = failures
+	progress := newProgressReporter(config.OnProgress).start(PhasePlan, totalFiles, totalBytes)
+	for _, assignment := range assignments {
+		if assignment.Partition < 0 {
+			continue
+		}
+
+		if err := planner.addAssignment(assignment); err != nil {
+			return nil, err
+		}
+		progress.add(assignment.Partition, assignment.File.Size)
+	}
+
+	progress.done()
+	return planner.plan, nil
+}
+
+// groupAssignments turns files already split into one group per output directory into assignments.
+func groupAssignments(groups [][]fileInfo, files []FileMeta) []Assignment {
+	byPath := make(map[string]FileMeta, len(files))
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+
+	var assignments []Assignment
+	for i, group := range groups {
+		for _, file := range group {
+			assignments = append(assignments, Assignment{File: byPath[file.path], Partition: i})
+		}
+	}
+	return assignments
+}
+
+// linkSource returns the file a link to file points to.
+func linkSource(file FileMeta) string {
+	if file.Target != "" {
+		return file.Target
+	}
+	return file.Path
+}
+
+func newFileInfo(file FileMeta) fileInfo {
+	return fileInfo{path: file.Path, size: file.Size, name: file.LinkName, target: file.Target}
+}
+
+func fileInfos(files []FileMeta) []fileInfo {
+	infos := make([]fileInfo, len(files))
+	for i, file := range files {
+		infos[i] = newFileInfo(file)
+	}
+	return infos
+}
+
+// countStrategy spreads files evenly across the partitions.
+type countStrategy struct{}
+
+func (countStrategy) Name() string { return strategyCount }
+
+func (countStrategy) Assign(files []FileMeta, outputDirs []string) ([]Assignment, error) {
+	assignments := make([]Assignment, len(files))
+	for i, file := range files {
+		assignments[i] = Assignment{File: file, Partition: i % len(outputDirs)}
+	}
+	return assignments, nil
+}
+
+// sizeStrategy balances the total size of the partitions, placing the largest files first.
+type sizeStrategy struct{}
+
+func (sizeStrategy) Name() string { return strategySize }
+
+func (sizeStrategy) Assign(files []FileMeta, outputDirs []string) ([]Assignment, error) {
+	return groupAssignments(partitionFilesBySize(fileInfos(files), len(outputDirs)), files), nil
+}
+
+// hashStrategy assigns files by consistent hashing of their relative paths.
+type hashStrategy struct{}
+
+func (hashStrategy) Name() string { return strategyHash }
+
+func (hashStrategy) Assign(files []FileMeta, outputDirs []string) ([]Assignment, error) {
+	ring := newHashRing(outputDirs)
+	assignments := make([]Assignment, len(files))
+	for i, file := range files {
+		assignments[i] = Assignment{File: file, Partition: ring.partition(file.RelPath)}
+	}
+	return assignments, nil
+}
+
+// mimeStrategy distributes MIME categories round-robin, each in its own subdirectory.
+type mimeStrategy struct{}
+
+func (mimeStrategy) Name() string    { return strategyMime }
+func (mimeStrategy) NeedsType() bool { return true }
+
+func (mimeStrategy) Assign(files []FileMeta, outputDirs []string) ([]Assignment, error) {
+	byType := make(map[string][]FileMeta)
+	for _, file := range files {
+		byType[file.Type] = append(byType[file.Type], file)
+	}
+
+	// Sort the categories so the same tree always produces the same plan
+	categories := make([]string, 0, len(byType))
+	for category := range byType {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	assignments := make([]Assignment, 0, len(files))
+	for i, category := range categories {
+		for _, file := range byType[category] {
+			assignments = append(assignments, Assignment{File: file, Partition: i % len(outputDirs), Group: category})
+		}
+	}
+	return assignments, nil
+}
@@ -0,0 +1,177 @@
+package trc
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// extensionStrategy keeps .log files in the first partition, everything else in the second,
+// each under a subdirectory named after the extension, and leaves out .tmp files.
+type extensionStrategy struct{}
+
+func (extensionStrategy) Name() string { return "test-extension" }
+
+func (extensionStrategy) Assign(files []FileMeta, outputDirs []string) ([]Assignment, error) {
+	var assignments []Assignment
+	for _, file := range files {
+		ext := strings.TrimPrefix(path.Ext(file.RelPath), ".")
+		switch ext {
+		case "tmp":
+			assignments = append(assignments, Assignment{File: file, Partition: -1})
+		case "log":
+			assignments = append(assignments, Assignment{File: file, Partition: 0, Group: ext})
+		default:
+			assignments = append(assignments, Assignment{File: file, Partition: 1, Group: ext})
+		}
+	}
+	return assignments, nil
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	if err := RegisterStrategy(extensionStrategy{}); err != nil {
+		t.Fatalf("RegisterStrategy failed: %v", err)
+	}
+
+	// Keep the test repeatable with -count
+	t.Cleanup(func() {
+		strategiesMu.Lock()
+		delete(strategies, "test-extension")
+		strategiesMu.Unlock()
+	})
+
+	if err := RegisterStrategy(extensionStrategy{}); err == nil {
+		t.Errorf("expected an error when registering a strategy twice")
+	}
+
+	if err := RegisterStrategy(countStrategy{}); err == nil {
+		t.Errorf("expected an error when replacing a built-in strategy")
+	}
+
+	for _, name := range []string{strategyCount, strategySize, strategyMime, strategyHash, "test-extension"} {
+		if _, ok := LookupStrategy(name); !ok {
+			t.Errorf("strategy %s is not registered, have %v", name, Strategies())
+		}
+	}
+
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	writeFile := func(name string) {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+
+	for _, name := range []string{"a.log", "b.txt", "c.tmp"} {
+		writeFile(name)
+	}
+
+	config := PartitionConfig{
+		SourceDir:  sourceDir,
+		OutputDirs: []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2")},
+		Strategy:   "test-extension",
+	}
+
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("Partitioning failed: %v", err)
+	}
+
+	expected := map[string]bool{
+		filepath.Join(config.OutputDirs[0], "log", "a.log"): true,
+		filepath.Join(config.OutputDirs[1], "txt", "b.txt"): true,
+	}
+
+	locations := linkLocations(t, config.OutputDirs)
+	if len(locations) != len(expected) {
+		t.Errorf("expected %d links, got %v", len(expected), locations)
+	}
+
+	for _, location := range locations {
+		if !expected[location] {
+			t.Errorf("unexpected link %s", location)
+		}
+	}
+
+	manifest, err := ReadManifest(config.OutputDirs[0])
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+
+	if manifest.Strategy != "test-extension" {
+		t.Errorf("expected the custom strategy in the manifest, got %q", manifest.Strategy)
+	}
+
+	// Syncing asks the strategy to place the new files only
+	writeFile("d.log")
+	report, err := SyncPartitions(config)
+	if err != nil {
+		t.Fatalf("SyncPartitions failed: %v", err)
+	}
+
+	if report.Added != 1 || report.Unchanged != 2 {
+		t.Errorf("unexpected sync report: %+v", report)
+	}
+
+	if _, err := os.Lstat(filepath.Join(config.OutputDirs[0], "log", "d.log")); err != nil {
+		t.Errorf("new file was not linked: %v", err)
+	}
+}
+
+func TestResolveStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   PartitionConfig
+		expected string
+		wantErr  bool
+	}{
+		{name: "default", config: PartitionConfig{}, expected: strategyMime},
+		{name: "by file", config: PartitionConfig{ByFile: true}, expected: strategyCount},
+		{name: "by size", config: PartitionConfig{BySize: true}, expected: strategySize},
+		{name: "by hash", config: PartitionConfig{ByHash: true}, expected: strategyHash},
+		{name: "by name", config: PartitionConfig{Strategy: strategySize}, expected: strategySize},
+		{name: "name agrees with flag", config: PartitionConfig{Strategy: strategySize, BySize: true}, expected: strategySize},
+		{name: "conflicting flags", config: PartitionConfig{ByFile: true, BySize: true}, wantErr: true},
+		{name: "name conflicts with flag", config: PartitionConfig{Strategy: strategyHash, ByFile: true}, wantErr: true},
+		{name: "unknown name", config: PartitionConfig{Strategy: "nope"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := resolveStrategy(tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got strategy %s", strategy.Name())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("resolveStrategy failed: %v", err)
+			}
+
+			if strategy.Name() != tt.expected {
+				t.Errorf("expected strategy %s, got %s", tt.expected, strategy.Name())
+			}
+		})
+	}
+}
+
+func TestPlanRejectsInvalidAssignments(t *testing.T) {
+	config := PartitionConfig{OutputDirs: []string{"partition1"}}
+	file := FileMeta{Path: "source/a.txt", RelPath: "a.txt"}
+
+	for _, assignment := range []Assignment{
+		{File: file, Partition: 1},
+		{File: file, Partition: 0, Group: "../escape"},
+	} {
+		if _, err := planAssignments([]Assignment{assignment}, config, "test", nil); err == nil {
+			t.Errorf("expected an error for assignment %+v", assignment)
+		}
+	}
+}
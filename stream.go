@@ -0,0 +1,312 @@
+package trc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// errStreamStopped is returned to the walk once a link failed, the link error itself is
+// reported by linkStream.close.
+var errStreamStopped = errors.New("stream stopped after a link failed")
+
+// streamPartitions partitions the source tree without planning first: every file is assigned as
+// soon as the walk finds it and handed to the link workers right away. Nothing but the files still
+// being linked is held in memory, except for the size strategy, which has to see every file
+// before placing the largest first and sorts them through a spillSorter.
+func streamPartitions(ctx context.Context, config PartitionConfig) error {
+	if len(config.OutputDirs) == 0 {
+		return errors.New("at least one output directory is required")
+	}
+
+	if config.Atomic {
+		return errors.New("streamed partitions cannot be built atomically, they are linked in place")
+	}
+
+	// A planned run drops the earlier of two links to the same path, a streamed one has already
+	// placed it, and only symlinks are ever replaced
+	if config.CollisionPolicy == CollisionOverwrite && config.LinkMode != LinkSymlink {
+		return fmt.Errorf("streamed partitions can only overwrite symlinks, not files placed with link mode %s", config.LinkMode)
+	}
+
+	config, err := withAbsSourceDir(config)
+	if err != nil {
+		return err
+	}
+
+	strategy, err := resolveStrategy(config)
+	if err != nil {
+		return err
+	}
+
+	assigner, err := newStreamAssigner(strategy.Name(), config)
+	if err != nil {
+		return err
+	}
+	defer assigner.close()
+
+	filter, err := newFileFilter(config)
+	if err != nil {
+		return err
+	}
+
+	// Fail before creating the partitions if there is nothing to walk
+	if info, err := os.Stat(config.SourceDir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", config.SourceDir)
+	}
+
+	changes := newJournal(config.SpillDir)
+	stream, err := newLinkStream(ctx, config, strategy.Name(), changes)
+	if err != nil {
+		return changes.finish(ctx, err)
+	}
+
+	filter.progress = stream.run.progress.start(PhaseWalk, 0, 0)
+	filter.failures = stream.failures
+	err = walkSource(ctx, config.SourceDir, filter, false, func(file sourceFile) error {
+		name, ok, err := filter.linkName(file.path)
+		if !ok {
+			return err
+		}
+
+		meta, err := newFileMeta(config.SourceDir, fileInfo{path: file.path, size: file.info.Size(), name: name, target: file.target})
+		if err != nil {
+			return err
+		}
+		return assigner.add(meta, stream.add)
+	})
+	if err == nil {
+		filter.progress.done()
+		err = assigner.flush(stream.add)
+	}
+
+	if errors.Is(err, errStreamStopped) {
+		err = nil
+	}
+	return changes.finish(ctx, errors.Join(err, stream.close(), stream.failures.err()))
+}
+
+// streamAssigner is the streaming counterpart of a Strategy. add is called for every file in
+// walk order and flush once the walk is over; both hand the assignments they decided on to
+// assign.
+type streamAssigner interface {
+	add(file FileMeta, assign func(Assignment) error) error
+	flush(assign func(Assignment) error) error
+	close() error
+}
+
+func newStreamAssigner(strategy string, config PartitionConfig) (streamAssigner, error) {
+	switch strategy {
+	case strategyCount:
+		return &countAssigner{partitions: len(config.OutputDirs)}, nil
+	case strategyHash:
+		return &hashAssigner{ring: newHashRing(config.OutputDirs)}, nil
+	case strategySize:
+		return newSizeAssigner(config), nil
+	default:
+		return nil, fmt.Errorf("the %s strategy cannot stream, use count, hash or size", strategy)
+	}
+}
+
+// countAssigner spreads files evenly across the partitions like countStrategy.
+type countAssigner struct {
+	partitions int
+	next       int
+}
+
+func (a *countAssigner) add(file FileMeta, assign func(Assignment) error) error {
+	partition := a.next % a.partitions
+	a.next++
+	return assign(Assignment{File: file, Partition: partition})
+}
+
+func (a *countAssigner) flush(func(Assignment) error) error { return nil }
+func (a *countAssigner) close() error                       { return nil }
+
+// hashAssigner assigns files by consistent hashing like hashStrategy.
+type hashAssigner struct {
+	ring *hashRing
+}
+
+func (a *hashAssigner) add(file FileMeta, assign func(Assignment) error) error {
+	return assign(Assignment{File: file, Partition: a.ring.partition(file.RelPath)})
+}
+
+func (a *hashAssigner) flush(func(Assignment) error) error { return nil }
+func (a *hashAssigner) close() error                       { return nil }
+
+// sizeAssigner balances the total size of the partitions like sizeStrategy. The files are only
+// assigned by flush, largest first, once all of them were sorted.
+type sizeAssigner struct {
+	files *spillSorter[FileMeta]
+	sizes []int64
+}
+
+func newSizeAssigner(config PartitionConfig) *sizeAssigner {
+	bySize := func(a, b FileMeta) int { return cmp.Compare(b.Size, a.Size) }
+	return &sizeAssigner{
+		files: newSpillSorter(config.SpillDir, bySize),
+		sizes: make([]int64, len(config.OutputDirs)),
+	}
+}
+
+func (a *sizeAssigner) add(file FileMeta, _ func(Assignment) error) error {
+	return a.files.add(file)
+}
+
+func (a *sizeAssigner) flush(assign func(Assignment) error) error {
+	return a.files.each(func(file FileMeta) error {
+		partition := findMinPartitionIndex(a.sizes)
+		a.sizes[partition] += file.Size
+		return assign(Assignment{File: file, Partition: partition})
+	})
+}
+
+func (a *sizeAssigner) close() error { return a.files.close() }
+
+// linkStream creates the links of a streaming run with a pool of LinkWorkers workers. Links are
+// handed to the workers by link path, so links that replace each other are created in order. A
+// link path is only claimed in the collision resolver while its link is being created, after
+// that the resolver finds it on disk.
+type linkStream struct {
+	config   PartitionConfig
+	run      *linkRun
+	resolver *collisionResolver
+	dirs     map[string]bool // Directories created so far
+	jobs     []chan linkJob  // One per worker
+	progress *phaseProgress  // Link progress, whose totals are not known while walking
+	failures *failureList    // Files left out with ContinueOnError, before they got to a worker
+
+	failed   chan struct{}
+	failOnce sync.Once
+	mu       sync.Mutex
+	errs     []error
+	wg       sync.WaitGroup
+}
+
+func newLinkStream(ctx context.Context, config PartitionConfig, strategy string, changes *journal) (*linkStream, error) {
+	run, err := newLinkRun(ctx, config, strategy, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &linkStream{
+		config:   config,
+		run:      run,
+		resolver: newCollisionResolver(config),
+		dirs:     make(map[string]bool),
+		failures: newFailureList(config),
+		failed:   make(chan struct{}),
+	}
+
+	// Every partition is created, even if no file ends up in it
+	for _, dir := range config.OutputDirs {
+		if err := s.ensureDirectory(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	s.progress = run.progress.start(PhaseLink, 0, 0)
+	for range workerCount(config.LinkWorkers) {
+		jobs := make(chan linkJob)
+		s.jobs = append(s.jobs, jobs)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for job := range jobs {
+				if err := s.run.create(job); err != nil {
+					s.mu.Lock()
+					s.errs = append(s.errs, err)
+					s.mu.Unlock()
+					if !config.ContinueOnError {
+						s.failOnce.Do(func() { close(s.failed) })
+					}
+				} else {
+					s.progress.add(job.partition, job.size)
+				}
+				s.resolver.forget(job.partition, job.linkPath, job.source)
+			}
+		}()
+	}
+
+	return s, nil
+}
+
+// add resolves where the link for an assignment goes and hands it to a worker. It returns
+// errStreamStopped once a link failed, and the error of the context once the run is cancelled.
+func (s *linkStream) add(assignment Assignment) error {
+	select {
+	case <-s.failed:
+		return errStreamStopped
+	case <-s.run.ctx.Done():
+		return s.run.ctx.Err()
+	default:
+	}
+
+	dir, err := assignmentDir(s.config, assignment)
+	if err != nil {
+		return err
+	}
+
+	linkPath, link, err := placeLink(s.config, s.resolver, assignment.Partition, dir, newFileInfo(assignment.File))
+	if err != nil {
+		return s.failures.skip(err)
+	}
+
+	if linkPath == "" {
+		return nil
+	}
+
+	if err := s.ensureDirectory(filepath.Dir(linkPath)); err != nil {
+		return err
+	}
+
+	job := linkJob{partition: assignment.Partition, linkPath: linkPath, source: link.Source, size: link.Size}
+	select {
+	case s.jobs[hashString(linkPath)%uint64(len(s.jobs))] <- job:
+		return nil
+	case <-s.failed:
+		s.resolver.forget(job.partition, job.linkPath, job.source)
+		return errStreamStopped
+	case <-s.run.ctx.Done():
+		s.resolver.forget(job.partition, job.linkPath, job.source)
+		return s.run.ctx.Err()
+	}
+}
+
+func (s *linkStream) ensureDirectory(dir string) error {
+	if s.dirs[dir] {
+		return nil
+	}
+
+	if err := s.run.changes.mkdirAll(dir); err != nil {
+		return err
+	}
+	s.dirs[dir] = true
+	return nil
+}
+
+// close waits for the links being created and writes the manifests, which record every link
+// created even if the run failed. Every link error is returned.
+func (s *linkStream) close() error {
+	for _, jobs := range s.jobs {
+		close(jobs)
+	}
+	s.wg.Wait()
+	s.progress.done()
+
+	// Workers fail in any order, report them in a stable one
+	sort.Slice(s.errs, func(i, j int) bool {
+		return s.errs[i].Error() < s.errs[j].Error()
+	})
+
+	return errors.Join(append(s.errs, s.run.finish())...)
+}
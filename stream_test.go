@@ -0,0 +1,167 @@
+package trc
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamMatchesPlan(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PartitionConfig
+	}{
+		{"Count", PartitionConfig{ByFile: true, CollisionPolicy: CollisionRenameSuffix}},
+		{"Hash", PartitionConfig{ByHash: true, CollisionPolicy: CollisionRenameHash}},
+		{"Size", PartitionConfig{BySize: true, CollisionPolicy: CollisionRenameSuffix}},
+		{"Size skipping collisions", PartitionConfig{BySize: true, CollisionPolicy: CollisionSkip, LinkWorkers: 1}},
+		{"Count preserving the tree", PartitionConfig{ByFile: true, PreserveTree: true}},
+		{"Count overwriting symlinks", PartitionConfig{ByFile: true, CollisionPolicy: CollisionOverwrite}},
+		{"Hardlinks", PartitionConfig{ByFile: true, CollisionPolicy: CollisionRenameSuffix, LinkMode: LinkHardlink}},
+		{"Copies skipping collisions", PartitionConfig{BySize: true, CollisionPolicy: CollisionSkip, LinkMode: LinkCopy, LinkWorkers: 1}},
+	}
+
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	for i := range 40 {
+		// Files named alike in different directories collide once flattened
+		path := filepath.Join(sourceDir, fmt.Sprintf("sub%d", i%4), fmt.Sprintf("file%02d.txt", i))
+		if i%10 == 0 {
+			path = filepath.Join(sourceDir, fmt.Sprintf("sub%d", i/10), "same.txt")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", i%7)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDirs := []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2"), filepath.Join(tempDir, "partition3")}
+			config := tt.config
+			config.SourceDir = sourceDir
+			config.OutputDirs = outputDirs
+			config.SpillDir = t.TempDir()
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("MakePartitions failed: %v", err)
+			}
+			planned := linkLocations(t, outputDirs)
+
+			for _, dir := range outputDirs {
+				if err := os.RemoveAll(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config.Stream = true
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("MakePartitions failed while streaming: %v", err)
+			}
+			streamed := linkLocations(t, outputDirs)
+
+			if len(planned) == 0 {
+				t.Error("expected planned links")
+			}
+
+			if !maps.Equal(planned, streamed) {
+				t.Errorf("streaming placed the files differently:\nplanned  %v\nstreamed %v", planned, streamed)
+			}
+
+			for _, dir := range outputDirs {
+				if err := os.RemoveAll(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 10 {
+		if err := os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("file%d.txt", i)), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outputDir := filepath.Join(tempDir, "partition1")
+
+	t.Run("Strategy that cannot stream", func(t *testing.T) {
+		err := MakePartitions(PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, Stream: true})
+		if err == nil || !strings.Contains(err.Error(), "cannot stream") {
+			t.Fatalf("expected the mime strategy to be refused, got %v", err)
+		}
+
+		if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+			t.Errorf("expected no partition to be created, got %v", err)
+		}
+	})
+
+	t.Run("Missing source", func(t *testing.T) {
+		err := MakePartitions(PartitionConfig{SourceDir: filepath.Join(tempDir, "missing"), OutputDirs: []string{outputDir}, ByFile: true, Stream: true})
+		if err == nil {
+			t.Fatal("expected an error for a missing source directory")
+		}
+
+		if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+			t.Errorf("expected no partition to be created, got %v", err)
+		}
+	})
+
+	t.Run("Overwriting placed files", func(t *testing.T) {
+		for _, mode := range []LinkMode{LinkHardlink, LinkCopy, LinkReflink, LinkMove} {
+			err := MakePartitions(PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true, LinkMode: mode, CollisionPolicy: CollisionOverwrite, Stream: true})
+			if err == nil || !strings.Contains(err.Error(), "can only overwrite symlinks") {
+				t.Fatalf("expected overwriting %s placements to be refused, got %v", mode, err)
+			}
+		}
+
+		if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+			t.Errorf("expected no partition to be created, got %v", err)
+		}
+	})
+
+	t.Run("Link failure", func(t *testing.T) {
+		if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(outputDir, "file5.txt"), []byte("precious"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := MakePartitions(PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true, CollisionPolicy: CollisionOverwrite, Stream: true})
+		if err == nil {
+			t.Fatal("expected the regular file to stop the run")
+		}
+
+		// The manifest lists exactly the links that were created
+		manifest, err := ReadManifest(outputDir)
+		if err != nil {
+			t.Fatalf("ReadManifest failed: %v", err)
+		}
+
+		for _, link := range manifest.Links {
+			if _, err := os.Readlink(filepath.Join(outputDir, filepath.FromSlash(link.Path))); err != nil {
+				t.Errorf("manifest lists %s, which is not a link: %v", link.Path, err)
+			}
+		}
+
+		if data, err := os.ReadFile(filepath.Join(outputDir, "file5.txt")); err != nil || string(data) != "precious" {
+			t.Errorf("expected the regular file to be left alone, got %q, %v", data, err)
+		}
+	})
+}
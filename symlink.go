@@ -1,121 +1,325 @@
 package trc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// createSymlinks handles the creation of symlinks for the provided files and output directories.
-// The `getPath` function is used to extract the file path from each element of the files slice.
-func createSymlinks[T any](files [][]T, outputDirs []string, getPath func(T) string) error {
-	for i, partition := range files {
-		for _, file := range partition {
-			filePath := getPath(file)
-			linkPath := filepath.Join(outputDirs[i], filepath.Base(filePath))
+// linkRun holds the state shared by every link created while applying a plan.
+type linkRun struct {
+	ctx       context.Context
+	config    PartitionConfig
+	linker    Linker
+	manifests *manifestBuilder
+	changes   *journal
+	progress  *progressReporter
+	log       *slog.Logger
+}
 
-			// Remove existing symlink or file before creating a new one
-			if err := removeExistingSymlink(linkPath); err != nil {
-				return err
-			}
+// LinkError is returned when a link of a partition cannot be created.
+type LinkError struct {
+	Path      string // Link that could not be created
+	Partition int    // Index of the partition in OutputDirs
+	Source    string // File the link was meant for
+	Err       error
+}
 
-			// Ensure the partition directory exists
-			if err := ensureDirectory(filepath.Dir(linkPath)); err != nil {
-				return err
+func (e *LinkError) Error() string {
+	return "failed to link " + e.Source + ": " + e.Err.Error()
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// linkJob is a single link handed to the workers of a linkRun.
+type linkJob struct {
+	partition int
+	linkPath  string
+	source    string
+	size      int64
+}
+
+func newLinkRun(ctx context.Context, config PartitionConfig, strategy string, changes *journal) (*linkRun, error) {
+	if config.LinkWorkers < 0 {
+		return nil, errors.New("the number of link workers cannot be negative")
+	}
+
+	linker, err := newLinker(config.LinkMode, config.RelativeLinks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkRun{
+		ctx:       ctx,
+		config:    config,
+		linker:    linker,
+		manifests: newManifestBuilder(config, strategy),
+		changes:   changes,
+		progress:  newProgressReporter(config.OnProgress),
+		log:       newLogger(config),
+	}, nil
+}
+
+// linkAll creates the links of every partition with a pool of LinkWorkers workers. The
+// directories the links live in must already exist. No new link is started after the first
+// failure, but the links already created are recorded in the manifests either way, so the
+// partitions can still be synced or removed. Every error is returned. Dispatching also stops once
+// the context of the run is cancelled.
+func (r *linkRun) linkAll(partitions []PlannedPartition) error {
+	var totalFiles int
+	var totalBytes int64
+	for _, partition := range partitions {
+		totalFiles += len(partition.Links)
+		for _, link := range partition.Links {
+			totalBytes += link.Size
+		}
+	}
+
+	progress := r.progress.start(PhaseLink, totalFiles, totalBytes)
+	jobs := make(chan linkJob)
+	failed := make(chan struct{})
+
+	var (
+		mu       sync.Mutex
+		errs     []error
+		failOnce sync.Once
+		wg       sync.WaitGroup
+	)
+
+	for range workerCount(r.config.LinkWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := r.create(job); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					if !r.config.ContinueOnError {
+						failOnce.Do(func() { close(failed) })
+					}
+					continue
+				}
+				progress.add(job.partition, job.size)
 			}
+		}()
+	}
 
-			// Create a symlink
-			if err := os.Symlink(filePath, linkPath); err != nil {
-				return fmt.Errorf("failed to create symlink from %s to %s: %w", filePath, linkPath, err)
+dispatch:
+	for i, partition := range partitions {
+		for _, link := range partition.Links {
+			select {
+			case jobs <- linkJob{partition: i, linkPath: partition.LinkPath(link), source: link.Source, size: link.Size}:
+			case <-failed:
+				break dispatch
+			case <-r.ctx.Done():
+				break dispatch
 			}
 		}
 	}
+
+	close(jobs)
+	wg.Wait()
+	progress.done()
+
+	// Workers fail in any order, report them in a stable one
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+
+	return errors.Join(append(errs, r.finish())...)
+}
+
+// create creates the link of a job, returning a *LinkError if it fails.
+func (r *linkRun) create(job linkJob) error {
+	if err := r.link(job.partition, job.linkPath, job.source); err != nil {
+		return &LinkError{Path: job.linkPath, Partition: job.partition, Source: job.source, Err: err}
+	}
 	return nil
 }
 
-// removeExistingSymlink removes an existing symlink or file, if it exists.
-func removeExistingSymlink(linkPath string) error {
-	if _, err := os.Lstat(linkPath); err == nil {
-		if err := os.Remove(linkPath); err != nil {
-			return fmt.Errorf("failed to remove existing symlink %s: %w", linkPath, err)
+// link places filePath at linkPath inside the given partition with the configured Linker and
+// records it in the partition manifest and the journal once it is in place. A placement of
+// filePath already at linkPath is kept and an existing symlink is replaced, anything else is an
+// error.
+func (r *linkRun) link(partition int, linkPath, filePath string) error {
+	// Describe the source before linking, a move takes it away
+	entry, err := r.manifests.entry(partition, linkPath, filePath)
+	if err != nil {
+		return err
+	}
+
+	var replaced string
+	if info, err := os.Lstat(linkPath); err == nil {
+		if r.linker.Same(filePath, linkPath) {
+			r.log.Debug("kept existing link", "path", linkPath, "source", filePath, "partition", partition)
+			return r.manifests.add(partition, entry)
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("cannot create %s %s: path already exists and is not a symlink", r.config.LinkMode, linkPath)
+		}
+
+		// Remember the symlink being replaced, so a rollback can put it back
+		if replaced, err = os.Readlink(linkPath); err != nil {
+			return fmt.Errorf("failed to read existing symlink %s: %w", linkPath, err)
 		}
-	} else if !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("failed to check symlink existence %s: %w", linkPath, err)
 	}
-	return nil
+
+	// Remove the link being replaced
+	if err := removeExistingSymlink(linkPath); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := r.linker.Link(filePath, linkPath); err != nil {
+		return err
+	}
+	logSlow(r.log, start, "slow link", "path", linkPath, "source", filePath, "mode", r.config.LinkMode, "size", entry.Size)
+	if replaced != "" {
+		r.log.Debug("replaced symlink", "path", linkPath, "previous", replaced)
+	}
+	r.log.Debug("linked file", "path", linkPath, "source", filePath, "partition", partition)
+
+	if err := r.changes.record(change{Op: changeLink, Path: linkPath, Mode: r.config.LinkMode, Link: entry, Target: replaced}); err != nil {
+		return err
+	}
+
+	return r.manifests.add(partition, entry)
 }
 
-// ensureDirectory ensures that the directory exists, creating it if necessary.
-func ensureDirectory(dir string) error {
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+// finish writes the manifest of every partition touched by the run, unless the run was cancelled
+// and is about to be rolled back.
+func (r *linkRun) finish() error {
+	if err := r.ctx.Err(); err != nil {
+		return errors.Join(err, r.manifests.close())
 	}
-	return nil
+	return r.manifests.write()
 }
 
-// createSymlinkTree creates symlinks in partition directories.
-func createSymlinkTree(files [][]string, outputDirs []string) error {
-	return createSymlinks(files, outputDirs, func(f string) string {
-		return f
-	})
+// resolveLinkPath returns where the link for filePath should live inside dir. By default links are
+// flattened into dir; with PreserveTree the path relative to the source directory is kept.
+func resolveLinkPath(config PartitionConfig, dir, filePath string) (string, error) {
+	if !config.PreserveTree {
+		return filepath.Join(dir, filepath.Base(filePath)), nil
+	}
+
+	relPath, err := filepath.Rel(config.SourceDir, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s relative to %s: %w", filePath, config.SourceDir, err)
+	}
+
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %s is outside of source directory %s", filePath, config.SourceDir)
+	}
+
+	return filepath.Join(dir, relPath), nil
 }
 
-// createSymlinkTreeBySize creates symlinks in partition directories, based on file sizes.
-func createSymlinkTreeBySize(files [][]fileInfo, outputDirs []string) error {
-	return createSymlinks(files, outputDirs, func(f fileInfo) string {
-		return f.path
-	})
+// symlinkTarget returns what a symlink at linkPath pointing to source should contain. With
+// relative set the target is relative to the link's directory, so the source and partitions can
+// be moved together; it stays absolute if no relative path exists, e.g. across Windows volumes.
+func symlinkTarget(linkPath, source string, relative bool) string {
+	if !relative {
+		return source
+	}
+
+	target, err := filepath.Rel(absPath(filepath.Dir(linkPath)), absPath(source))
+	if err != nil {
+		return source
+	}
+	return target
 }
 
-// createSymlinkWithMimeType creates symlinks for files based on their MIME type, organizing them into categories.
-func createSymlinkWithMimeType(mimeMap map[string][]string, destDir string) error {
-	for category, files := range mimeMap {
-		categoryFolder := filepath.Join(destDir, category)
-		if err := ensureDirectory(categoryFolder); err != nil {
-			return err
-		}
+// resolveSymlink returns the absolute path the symlink at linkPath points to, resolving a
+// relative target from the link's directory.
+func resolveSymlink(linkPath string) (string, error) {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return "", err
+	}
 
-		for _, file := range files {
-			linkPath := filepath.Join(categoryFolder, filepath.Base(file))
-			if err := os.Symlink(file, linkPath); err != nil {
-				return fmt.Errorf("failed to create symlink for %s: %w", file, err)
-			}
-		}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(linkPath), target)
 	}
+	return absPath(target), nil
+}
 
-	return nil
+// pointsTo reports whether the symlink at linkPath is the link recorded in the manifest. A
+// relative link is compared as written, so it still matches after the tree was moved.
+func pointsTo(linkPath string, link ManifestLink) bool {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return false
+	}
+
+	if link.Relative != "" {
+		return target == link.Relative
+	}
+
+	if target == link.Target {
+		return true
+	}
+
+	resolved, err := resolveSymlink(linkPath)
+	return err == nil && resolved == absPath(link.Target)
 }
 
-// removeSymlinkTree removes all symlinks within the provided directories.
-func removeSymlinkTree(outputDirs []string) error {
-	for _, dir := range outputDirs {
-		if err := walkAndRemoveSymlinks(dir); err != nil {
-			return err
+// removeExistingSymlink removes an existing symlink or file, if it exists.
+func removeExistingSymlink(linkPath string) error {
+	if _, err := os.Lstat(linkPath); err == nil {
+		if err := os.Remove(linkPath); err != nil {
+			return fmt.Errorf("failed to remove existing symlink %s: %w", linkPath, err)
 		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check symlink existence %s: %w", linkPath, err)
 	}
 	return nil
 }
 
-// walkAndRemoveSymlinks walks through a directory tree and removes all symlinks found.
-func walkAndRemoveSymlinks(dir string) error {
+// ensureDirectory ensures that the directory exists, creating it if necessary.
+func ensureDirectory(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// walkAndRemoveSymlinks walks through a directory tree and removes all symlinks found, recording
+// them in changes, and returns their paths. It stops once ctx is cancelled.
+func walkAndRemoveSymlinks(ctx context.Context, dir string, changes *journal) ([]string, error) {
+	var removed []string
 	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("failed to access path %s: %w", path, err)
 		}
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Check if the file is a symlink
 		if info.Mode()&os.ModeSymlink != 0 {
-			if err := os.Remove(path); err != nil {
-				return fmt.Errorf("failed to remove symlink %s: %w", path, err)
+			if err := changes.removeSymlink(path); err != nil {
+				return err
 			}
+			removed = append(removed, path)
 		}
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to remove symlinks in directory %s: %w", dir, err)
+		return removed, fmt.Errorf("failed to remove symlinks in directory %s: %w", dir, err)
 	}
-	return nil
+	return removed, nil
 }
@@ -7,6 +7,16 @@ import (
 	"testing"
 )
 
+// createSymlinkTree plans and applies the links for files already split into partitions.
+func createSymlinkTree(files [][]fileInfo, config PartitionConfig) error {
+	plan, err := planPartitions(files, config, strategyCount)
+	if err != nil {
+		return err
+	}
+
+	return plan.Apply()
+}
+
 func TestCreateSymlinkTree(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -71,7 +81,16 @@ func TestCreateSymlinkTree(t *testing.T) {
 			}
 
 			// Create symlinks
-			err := createSymlinkTree(tt.partitions, outputDirs)
+			var partitions [][]fileInfo
+			for _, partition := range tt.partitions {
+				var files []fileInfo
+				for _, file := range partition {
+					files = append(files, fileInfo{path: file})
+				}
+				partitions = append(partitions, files)
+			}
+
+			err := createSymlinkTree(partitions, PartitionConfig{OutputDirs: outputDirs})
 
 			// Check for expected error
 			if (err != nil) != tt.expectedErr {
@@ -157,7 +176,7 @@ func TestCreateSymlinkTreeBySize(t *testing.T) {
 			}
 
 			// Create symlinks
-			err := createSymlinkTreeBySize(tt.partitions, outputDirs)
+			err := createSymlinkTree(tt.partitions, PartitionConfig{OutputDirs: outputDirs})
 
 			// Check for expected error
 			if (err != nil) != tt.expectedErr {
@@ -180,7 +199,7 @@ func TestCreateSymlinkTreeBySize(t *testing.T) {
 	}
 }
 
-func TestRemoveSymlinkTree(t *testing.T) {
+func TestRemovePartitionsForce(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Define test cases
@@ -259,8 +278,8 @@ func TestRemoveSymlinkTree(t *testing.T) {
 				}
 			}
 
-			// Call removeSymlinkTree
-			err := removeSymlinkTree(tt.directories)
+			// Without a manifest, Force removes every symlink
+			err := RemovePartitionsWithConfig(PartitionConfig{OutputDirs: tt.directories, Force: true})
 			if (err != nil) != tt.expectedErr {
 				t.Errorf("expected error: %v, got: %v", tt.expectedErr, err)
 			}
@@ -282,3 +301,139 @@ func TestRemoveSymlinkTree(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveLinkPath(t *testing.T) {
+	sourceDir := filepath.Join("data", "source")
+
+	tests := []struct {
+		name         string
+		preserveTree bool
+		filePath     string
+		expected     string
+		expectedErr  bool
+	}{
+		{
+			name:     "flattened",
+			filePath: filepath.Join(sourceDir, "a", "2024", "report.csv"),
+			expected: filepath.Join("part", "report.csv"),
+		},
+		{
+			name:         "preserve tree",
+			preserveTree: true,
+			filePath:     filepath.Join(sourceDir, "a", "2024", "report.csv"),
+			expected:     filepath.Join("part", "a", "2024", "report.csv"),
+		},
+		{
+			name:         "preserve tree top level file",
+			preserveTree: true,
+			filePath:     filepath.Join(sourceDir, "report.csv"),
+			expected:     filepath.Join("part", "report.csv"),
+		},
+		{
+			name:         "outside of source",
+			preserveTree: true,
+			filePath:     filepath.Join("data", "other", "report.csv"),
+			expectedErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := PartitionConfig{SourceDir: sourceDir, PreserveTree: tt.preserveTree}
+
+			got, err := resolveLinkPath(config, "part", tt.filePath)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error: %v, got: %v", tt.expectedErr, err)
+			}
+
+			if got != tt.expected {
+				t.Errorf("resolveLinkPath(%q) = %q; want %q", tt.filePath, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSymlinkTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		relative bool
+	}{
+		{name: "absolute"},
+		{name: "relative", relative: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			treeDir := filepath.Join(tempDir, "tree")
+			if err := os.MkdirAll(filepath.Join(treeDir, "source", "nested"), os.ModePerm); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+
+			if err := os.WriteFile(filepath.Join(treeDir, "source", "nested", "a.txt"), []byte("a"), 0644); err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+
+			// A relative source directory must still produce links that resolve from the partition
+			t.Chdir(treeDir)
+			config := PartitionConfig{
+				SourceDir:     "source",
+				OutputDirs:    []string{"partition1"},
+				ByFile:        true,
+				PreserveTree:  true,
+				RelativeLinks: tt.relative,
+			}
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("Partitioning failed: %v", err)
+			}
+
+			linkPath := filepath.Join("partition1", "nested", "a.txt")
+			target, err := os.Readlink(linkPath)
+			if err != nil {
+				t.Fatalf("Readlink failed: %v", err)
+			}
+
+			expected := filepath.Join(treeDir, "source", "nested", "a.txt")
+			if tt.relative {
+				expected = filepath.Join("..", "..", "source", "nested", "a.txt")
+			}
+
+			if target != expected {
+				t.Errorf("expected link target %s, got %s", expected, target)
+			}
+
+			if _, err := os.Stat(linkPath); err != nil {
+				t.Errorf("link does not resolve: %v", err)
+			}
+
+			if !tt.relative {
+				return
+			}
+
+			// Relative links survive moving the source and partitions together
+			movedDir := filepath.Join(tempDir, "moved")
+			if err := os.Rename(treeDir, movedDir); err != nil {
+				t.Fatalf("error moving tree: %v", err)
+			}
+			t.Chdir(movedDir)
+
+			report, err := VerifyPartitions(config)
+			if err != nil {
+				t.Fatalf("VerifyPartitions failed: %v", err)
+			}
+
+			if !report.OK() {
+				t.Errorf("links broke after moving the tree: %+v", report.Problems)
+			}
+
+			if err := RemovePartitions(config.OutputDirs); err != nil {
+				t.Fatalf("RemovePartitions failed: %v", err)
+			}
+
+			if _, err := os.Lstat("partition1"); !os.IsNotExist(err) {
+				t.Errorf("expected partition1 to be removed after moving the tree")
+			}
+		})
+	}
+}
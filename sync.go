@@ -0,0 +1,270 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SyncReport summarizes the changes made by SyncPartitions.
+type SyncReport struct {
+	Added     int // Files linked for the first time
+	Removed   int // Links removed because their source no longer exists
+	Unchanged int // Links left exactly where they were
+}
+
+// SyncPartitions brings existing partitions up to date with the source tree without moving any
+// file that is already linked. Symlinks whose source was deleted are removed; with other link
+// modes the partition holds the only copy of such a file, so it is kept. New files are
+// added to the partition that keeps the balance of the configured strategy best: the fewest
+// files for count, the smallest total size for size, and the partition already holding the
+// file's MIME category for mime. With the hash strategy new files simply go to the partition
+// their path hashes to, and any other registered Strategy is asked to assign the new files only.
+// The partition manifests tell which links trc owns, so partitions without a manifest are
+// treated as empty.
+//
+// With ContinueOnError the files that fail are left out and the report is returned along with
+// their errors. Links are then only removed if the whole source tree could be walked, since a
+// file that could not be read would look deleted.
+func SyncPartitions(config PartitionConfig) (*SyncReport, error) {
+	return SyncPartitionsContext(context.Background(), config)
+}
+
+// SyncPartitionsContext is SyncPartitions with a context. Once ctx is cancelled the run stops and
+// is rolled back: the links it removed are put back and the links it created are removed.
+func SyncPartitionsContext(ctx context.Context, config PartitionConfig) (*SyncReport, error) {
+	changes := newJournal(config.SpillDir)
+	report, err := syncPartitions(ctx, config, changes)
+	if err = changes.finish(ctx, err); err != nil && (report == nil || ctx.Err() != nil) {
+		return nil, err
+	}
+	return report, err
+}
+
+// syncPartitions syncs the partitions, recording every change in changes.
+func syncPartitions(ctx context.Context, config PartitionConfig, changes *journal) (*SyncReport, error) {
+	if len(config.OutputDirs) == 0 {
+		return nil, errors.New("at least one output directory is required")
+	}
+
+	if config.Atomic {
+		return nil, errors.New("partitions cannot be synced atomically, they are updated in place")
+	}
+
+	config, err := withAbsSourceDir(config)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := resolveStrategy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	failures := newFailureList(config)
+	files, err := collectSyncFiles(ctx, config, strategy, failures)
+	if err != nil {
+		return nil, err
+	}
+
+	// A source file that could not be read would look deleted, only remove links after a full walk
+	walked := failures.err() == nil
+
+	report := &SyncReport{}
+	partitions := len(config.OutputDirs)
+	counts := make([]int, partitions)
+	sizes := make([]int64, partitions)
+	categories := make([]map[string]bool, partitions)
+	linked := make(map[string]bool)
+
+	for i, dir := range config.OutputDirs {
+		categories[i] = make(map[string]bool)
+
+		manifest, err := ReadManifest(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if manifest.Strategy != "" && manifest.Strategy != strategy.Name() {
+			return nil, fmt.Errorf("partition %s was created with the %s strategy, cannot sync it with %s", dir, manifest.Strategy, strategy.Name())
+		}
+
+		if manifest.Mode != config.LinkMode {
+			return nil, fmt.Errorf("partition %s was created with link mode %s, cannot sync it with %s", dir, manifest.Mode, config.LinkMode)
+		}
+
+		for _, link := range manifest.Links {
+			linkPath := filepath.Join(dir, filepath.FromSlash(link.Path))
+			current, exists := files[absPath(link.Target)]
+
+			// Other link modes leave the only copy of a deleted source in the partition, keep it
+			if !exists && walked && config.LinkMode == LinkSymlink {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+
+				// Anything else at that path is no longer the link trc created and is left alone
+				if pointsTo(linkPath, link) {
+					if err := changes.removeSymlink(linkPath); err != nil {
+						return nil, err
+					}
+					newLogger(config).Debug("removed link to deleted file", "path", linkPath, "source", link.Target)
+					report.Removed++
+				}
+				continue
+			}
+
+			// A link deleted by hand is planned again like a new file
+			if _, err := os.Lstat(linkPath); err != nil {
+				continue
+			}
+
+			size := link.Size
+			if exists {
+				size = current.Size
+			}
+
+			linked[absPath(link.Target)] = true
+			counts[i]++
+			sizes[i] += size
+			categories[i][strings.SplitN(link.Path, "/", 2)[0]] = true
+			report.Unchanged++
+		}
+	}
+
+	var added []FileMeta
+	for key, file := range files {
+		if !linked[key] {
+			added = append(added, file)
+		}
+	}
+
+	// Place the largest files first when balancing by size, like partitionFilesBySize does
+	sort.Slice(added, func(i, j int) bool {
+		if strategy.Name() == strategySize && added[i].Size != added[j].Size {
+			return added[i].Size > added[j].Size
+		}
+		return added[i].Path < added[j].Path
+	})
+
+	var assignments []Assignment
+	ring := newHashRing(config.OutputDirs)
+	for _, file := range added {
+		var partition int
+		switch strategy.Name() {
+		case strategyHash:
+			partition = ring.partition(file.RelPath)
+		case strategyCount:
+			partition = findMinCountIndex(counts)
+		case strategySize:
+			partition = findMinPartitionIndex(sizes)
+		case strategyMime:
+			partition = findCategoryPartition(categories, counts, file.Type)
+		default:
+			continue
+		}
+
+		// The mime strategy keeps every category in its own subdirectory
+		assignments = append(assignments, Assignment{File: file, Partition: partition, Group: file.Type})
+		counts[partition]++
+		sizes[partition] += file.Size
+		categories[partition][file.Type] = true
+	}
+
+	if !isBuiltinStrategy(strategy.Name()) && len(added) > 0 {
+		if assignments, err = strategy.Assign(added, config.OutputDirs); err != nil {
+			return nil, fmt.Errorf("%s strategy failed: %w", strategy.Name(), err)
+		}
+	}
+
+	plan, err := planAssignments(assignments, config, strategy.Name(), failures)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Added = plan.TotalFiles()
+	if err := plan.apply(ctx, changes); err != nil {
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+
+		// Links that failed are listed along with the files that could not be walked or planned,
+		// anything else still fails the run
+		for _, linkErr := range errs {
+			if _, ok := linkErr.(*LinkError); !ok || !config.ContinueOnError || ctx.Err() != nil {
+				return nil, err
+			}
+			failures.skip(linkErr)
+			report.Added--
+		}
+	}
+
+	return report, failures.err()
+}
+
+// collectSyncFiles collects the current source files keyed by the absolute path their links point to.
+func collectSyncFiles(ctx context.Context, config PartitionConfig, strategy Strategy, failures *failureList) (map[string]FileMeta, error) {
+	collected, err := collectFileMeta(ctx, config, strategy, failures)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]FileMeta, len(collected))
+	for _, file := range collected {
+		files[absPath(linkSource(file))] = file
+	}
+
+	return files, nil
+}
+
+// findMinCountIndex returns the index of the partition with the fewest files.
+func findMinCountIndex(counts []int) int {
+	minIndex := 0
+	for i := 1; i < len(counts); i++ {
+		if counts[i] < counts[minIndex] {
+			minIndex = i
+		}
+	}
+	return minIndex
+}
+
+// findCategoryPartition returns the first partition that already holds the category, or the
+// partition with the fewest files if none does.
+func findCategoryPartition(categories []map[string]bool, counts []int, category string) int {
+	for i, partitionCategories := range categories {
+		if partitionCategories[category] {
+			return i
+		}
+	}
+	return findMinCountIndex(counts)
+}
+
+// removeLinkTo removes linkPath if it is still the symlink recorded in the manifest. Anything
+// else at that path is no longer the link trc created and is left alone.
+func removeLinkTo(linkPath string, link ManifestLink) error {
+	if !pointsTo(linkPath, link) {
+		return nil
+	}
+
+	if err := os.Remove(linkPath); err != nil {
+		return fmt.Errorf("failed to remove dangling symlink %s: %w", linkPath, err)
+	}
+	return nil
+}
+
+// absPath returns the absolute form of path, or path itself if it cannot be resolved.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
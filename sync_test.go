@@ -0,0 +1,174 @@
+package trc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncPartitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PartitionConfig
+	}{
+		{name: "by count", config: PartitionConfig{ByFile: true}},
+		{name: "by size", config: PartitionConfig{BySize: true}},
+		{name: "by type", config: PartitionConfig{}},
+		{name: "by hash", config: PartitionConfig{ByHash: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+
+			writeFile := func(name string) {
+				if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("some plain text for "+name), 0644); err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+			}
+
+			for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+				writeFile(name)
+			}
+
+			config := tt.config
+			config.SourceDir = sourceDir
+			config.OutputDirs = []string{filepath.Join(tempDir, "partition1"), filepath.Join(tempDir, "partition2")}
+
+			if err := MakePartitions(config); err != nil {
+				t.Fatalf("Partitioning failed: %v", err)
+			}
+
+			before := linkLocations(t, config.OutputDirs)
+
+			// One file is deleted and two new ones appear
+			if err := os.Remove(filepath.Join(sourceDir, "b.txt")); err != nil {
+				t.Fatalf("error removing file: %v", err)
+			}
+			writeFile("e.txt")
+			writeFile("f.txt")
+
+			report, err := SyncPartitions(config)
+			if err != nil {
+				t.Fatalf("SyncPartitions failed: %v", err)
+			}
+
+			if report.Added != 2 || report.Removed != 1 || report.Unchanged != 3 {
+				t.Errorf("unexpected report: %+v", report)
+			}
+
+			after := linkLocations(t, config.OutputDirs)
+			for target, location := range before {
+				if filepath.Base(target) == "b.txt" {
+					if _, ok := after[target]; ok {
+						t.Errorf("link to deleted file %s was not removed", target)
+					}
+					continue
+				}
+
+				if after[target] != location {
+					t.Errorf("existing link to %s moved from %s to %s", target, location, after[target])
+				}
+			}
+
+			if len(after) != 5 {
+				t.Errorf("expected 5 links after sync, got %d", len(after))
+			}
+
+			// Syncing an up-to-date tree changes nothing
+			report, err = SyncPartitions(config)
+			if err != nil {
+				t.Fatalf("second SyncPartitions failed: %v", err)
+			}
+
+			if report.Added != 0 || report.Removed != 0 || report.Unchanged != 5 {
+				t.Errorf("unexpected report for up-to-date partitions: %+v", report)
+			}
+		})
+	}
+}
+
+func TestSyncPartitionsBalancesCount(t *testing.T) {
+	counts := []int{3, 1, 2}
+	if got := findMinCountIndex(counts); got != 1 {
+		t.Errorf("findMinCountIndex(%v) = %d; want 1", counts, got)
+	}
+
+	categories := []map[string]bool{{"text": true}, {"image": true}, {}}
+	if got := findCategoryPartition(categories, counts, "image"); got != 1 {
+		t.Errorf("expected image files to join partition 1, got %d", got)
+	}
+
+	if got := findCategoryPartition(categories, []int{3, 1, 0}, "audio"); got != 2 {
+		t.Errorf("expected a new category to go to the emptiest partition, got %d", got)
+	}
+}
+
+// linkLocations maps the manifest target of every link to its path.
+func linkLocations(t *testing.T, outputDirs []string) map[string]string {
+	t.Helper()
+
+	locations := make(map[string]string)
+	for _, dir := range outputDirs {
+		manifest, err := ReadManifest(dir)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %v", err)
+		}
+
+		for _, link := range manifest.Links {
+			locations[link.Target] = filepath.Join(dir, link.Path)
+		}
+	}
+	return locations
+}
+
+func TestSyncPartitionsKeepsReplacedLinks(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("content"), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+
+	outputDir := filepath.Join(tempDir, "partition1")
+	config := PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{outputDir}, ByFile: true}
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("Partitioning failed: %v", err)
+	}
+
+	// The source is deleted and the user puts a file of their own where its link was
+	if err := os.Remove(filepath.Join(sourceDir, "b.txt")); err != nil {
+		t.Fatalf("error removing file: %v", err)
+	}
+
+	userFile := filepath.Join(outputDir, "b.txt")
+	if err := os.Remove(userFile); err != nil {
+		t.Fatalf("error removing link: %v", err)
+	}
+
+	if err := os.WriteFile(userFile, []byte("mine"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	report, err := SyncPartitions(config)
+	if err != nil {
+		t.Fatalf("SyncPartitions failed: %v", err)
+	}
+
+	if report.Removed != 0 || report.Unchanged != 1 {
+		t.Errorf("expected nothing to be removed, got %+v", report)
+	}
+
+	if data, err := os.ReadFile(userFile); err != nil || string(data) != "mine" {
+		t.Errorf("expected the user file to be left alone, got %q, %v", data, err)
+	}
+}
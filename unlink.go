@@ -0,0 +1,297 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotPartition is returned when unlinking a directory that has no trc manifest.
+var ErrNotPartition = errors.New("not a trc partition")
+
+// removePartition removes the links trc created inside dir and prunes the directories that
+// removing them left empty. Without a manifest the directory is refused unless config.Force is
+// set. Partitions made with a link mode other than symlink are undone with the matching Linker.
+// Every change is recorded in changes, and removing stops once ctx is cancelled.
+func removePartition(ctx context.Context, dir string, config PartitionConfig, changes *journal) error {
+	info, err := os.Lstat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to access partition directory %s: %w", dir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("partition %s is not a directory", dir)
+	}
+
+	manifest, err := ReadManifest(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if manifest == nil && !config.Force {
+		return fmt.Errorf("refusing to unlink %s: %w (no %s found, use --force to remove its symlinks anyway)",
+			dir, ErrNotPartition, filepath.Join(ManifestDir, ManifestFile))
+	}
+
+	log := newLogger(config)
+	if manifest != nil && manifest.Mode != LinkSymlink {
+		if err := removePlacedFiles(ctx, dir, manifest, changes, log); err != nil {
+			return err
+		}
+
+		log.Info("removed partition", "dir", dir, "mode", manifest.Mode)
+		return nil
+	}
+
+	// Force only lifts the refusal of directories without a manifest: with a source directory
+	// given, the symlinks pointing elsewhere are still left alone
+	var removed []string
+	switch {
+	case manifest == nil && config.SourceDir == "":
+		log.Warn("removing every symlink from a directory without manifest", "dir", dir)
+		removed, err = walkAndRemoveSymlinks(ctx, dir, changes)
+	case manifest == nil:
+		log.Warn("removing the symlinks into the source directory from a directory without manifest", "dir", dir, "source", config.SourceDir)
+		removed, err = removeOwnedSymlinks(ctx, dir, newOwnedLinks(dir, nil, config.SourceDir), changes, log)
+	default:
+		removed, err = removeOwnedSymlinks(ctx, dir, newOwnedLinks(dir, manifest, config.SourceDir), changes, log)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := removeManifest(dir, changes); err != nil {
+		return err
+	}
+
+	if err := pruneEmptyDirs(dir, append(removed, filepath.Join(dir, ManifestDir)), changes); err != nil {
+		return err
+	}
+
+	log.Info("removed partition", "dir", dir, "mode", LinkSymlink)
+	return nil
+}
+
+// removeManifest removes the manifest directory of a partition, recording the manifest in changes.
+func removeManifest(dir string, changes *journal) error {
+	if err := changes.saveManifest(dir); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, ManifestDir)); err != nil {
+		return fmt.Errorf("failed to remove manifest of %s: %w", dir, err)
+	}
+	return nil
+}
+
+// removePlacedFiles takes the files listed in the manifest out of a partition made with a link
+// mode other than symlink. Files the Linker keeps, because they changed or hold the only copy of
+// their source, stay listed in the manifest and are reported together.
+func removePlacedFiles(ctx context.Context, dir string, manifest *Manifest, changes *journal, log *slog.Logger) error {
+	linker, err := NewLinker(manifest.Mode)
+	if err != nil {
+		return err
+	}
+
+	var kept []ManifestLink
+	var removed []string
+	var errs []error
+	for _, link := range manifest.Links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(link.Path))
+		if _, err := os.Lstat(dest); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err := linker.Remove(dest, link); err != nil {
+			log.Warn("kept file in partition", "path", dest, "error", err)
+			kept = append(kept, link)
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := changes.record(change{Op: changeUnlink, Path: dest, Mode: manifest.Mode, Link: link}); err != nil {
+			return err
+		}
+		removed = append(removed, dest)
+		log.Debug("removed file", "path", dest, "mode", manifest.Mode)
+	}
+
+	if len(kept) > 0 {
+		manifest.Links = kept
+		if err := changes.saveManifest(dir); err != nil {
+			errs = append(errs, err)
+		} else if err := WriteManifest(dir, manifest); err != nil {
+			errs = append(errs, err)
+		}
+	} else if err := removeManifest(dir, changes); err != nil {
+		errs = append(errs, err)
+	} else {
+		removed = append(removed, filepath.Join(dir, ManifestDir))
+	}
+
+	if err := pruneEmptyDirs(dir, removed, changes); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// removeOwnedSymlinks walks through a partition and removes the symlinks trc created, recording
+// them in changes, and returns their paths. It stops once ctx is cancelled.
+func removeOwnedSymlinks(ctx context.Context, dir string, owned *ownedLinks, changes *journal, log *slog.Logger) ([]string, error) {
+	var removed []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to access path %s: %w", path, err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if isManifestDir(dir, path, d.IsDir()) {
+			return filepath.SkipDir
+		}
+
+		if d.Type()&fs.ModeSymlink == 0 || !owned.contains(path) {
+			return nil
+		}
+
+		if err := changes.removeSymlink(path); err != nil {
+			return err
+		}
+		removed = append(removed, path)
+		log.Debug("removed link", "path", path)
+		return nil
+	})
+
+	if err != nil {
+		return removed, fmt.Errorf("failed to remove symlinks in directory %s: %w", dir, err)
+	}
+	return removed, nil
+}
+
+// ownedLinks decides whether a symlink inside a partition was created by trc: either the
+// manifest lists it with the same target, or it points into one of the recorded source trees.
+type ownedLinks struct {
+	dir         string
+	manifest    map[string]ManifestLink // relative link path -> link
+	sourceRoots []string
+}
+
+func newOwnedLinks(dir string, manifest *Manifest, sourceDir string) *ownedLinks {
+	owned := &ownedLinks{dir: dir, manifest: make(map[string]ManifestLink)}
+
+	if manifest != nil {
+		for _, link := range manifest.Links {
+			owned.manifest[link.Path] = link
+		}
+
+		owned.addSourceRoot(manifest.SourceDir)
+	}
+
+	owned.addSourceRoot(sourceDir)
+	return owned
+}
+
+func (o *ownedLinks) addSourceRoot(root string) {
+	if root == "" {
+		return
+	}
+
+	if abs, err := filepath.Abs(root); err == nil {
+		o.sourceRoots = append(o.sourceRoots, abs)
+	}
+}
+
+func (o *ownedLinks) contains(linkPath string) bool {
+	if relPath, err := filepath.Rel(o.dir, linkPath); err == nil {
+		if recorded, ok := o.manifest[filepath.ToSlash(relPath)]; ok && pointsTo(linkPath, recorded) {
+			return true
+		}
+	}
+
+	target, err := resolveSymlink(linkPath)
+	if err != nil {
+		return false
+	}
+
+	for _, root := range o.sourceRoots {
+		if target == root || strings.HasPrefix(target, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneEmptyDirs removes the directories that held one of the removed paths, and their parents up
+// to and including root, once they are empty. Deeper directories go first, so parents emptied by
+// their children are removed too. Directories that still hold anything, and directories nothing was
+// removed from, are left alone. Every directory removed is recorded in changes.
+func pruneEmptyDirs(root string, removed []string, changes *journal) error {
+	candidates := make(map[string]bool)
+	for _, path := range removed {
+		for dir := filepath.Dir(path); !candidates[dir]; dir = filepath.Dir(dir) {
+			relPath, err := filepath.Rel(root, dir)
+			if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+				break
+			}
+
+			candidates[dir] = true
+			if relPath == "." {
+				break
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+
+	// A directory is longer than any of its parents, so the longest paths go first
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", dir, err)
+		}
+
+		if len(entries) > 0 {
+			continue
+		}
+
+		if err := os.Remove(dir); err != nil {
+			return fmt.Errorf("failed to remove empty directory %s: %w", dir, err)
+		}
+
+		if err := changes.record(change{Op: changeRmdir, Path: dir}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package trc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemovePartitions(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	outsideFile := filepath.Join(tempDir, "outside.txt")
+
+	for _, path := range []string{
+		filepath.Join(sourceDir, "a", "one.txt"),
+		filepath.Join(sourceDir, "b", "two.txt"),
+		outsideFile,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+
+	partition1 := filepath.Join(tempDir, "partition1")
+	partition2 := filepath.Join(tempDir, "partition2")
+	config := PartitionConfig{
+		SourceDir:    sourceDir,
+		OutputDirs:   []string{partition1, partition2},
+		ByFile:       true,
+		PreserveTree: true,
+	}
+
+	if err := MakePartitions(config); err != nil {
+		t.Fatalf("Partitioning failed: %v", err)
+	}
+
+	// Things trc did not create must survive the unlink
+	userFile := filepath.Join(partition1, "notes.txt")
+	if err := os.WriteFile(userFile, []byte("keep me"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	// Empty directories that were there before the unlink are not trc's to prune
+	userDirs := []string{filepath.Join(partition1, "user-empty"), filepath.Join(partition2, "b", "user-empty")}
+	for _, dir := range userDirs {
+		if err := os.Mkdir(dir, os.ModePerm); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+	}
+
+	foreignLink := filepath.Join(partition2, "foreign.txt")
+	if err := os.Symlink(outsideFile, foreignLink); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	if err := RemovePartitions(config.OutputDirs); err != nil {
+		t.Fatalf("RemovePartitions failed: %v", err)
+	}
+
+	if _, err := os.Stat(userFile); err != nil {
+		t.Errorf("regular file inside a partition was removed: %v", err)
+	}
+
+	if _, err := os.Lstat(foreignLink); err != nil {
+		t.Errorf("symlink pointing outside the source tree was removed: %v", err)
+	}
+
+	for _, dir := range userDirs {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("empty directory that existed before the unlink was removed: %v", err)
+		}
+	}
+
+	for _, path := range []string{
+		filepath.Join(partition1, "a"),
+		filepath.Join(partition1, ManifestDir),
+		filepath.Join(partition2, ManifestDir),
+	} {
+		if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got: %v", path, err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(sourceDir, "a", "one.txt"), filepath.Join(sourceDir, "b", "two.txt")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("source file %s was removed: %v", path, err)
+		}
+	}
+}
+
+func TestRemovePartitionsWithoutManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "file.txt")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	dir := filepath.Join(tempDir, "important")
+	if err := os.Mkdir(dir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	regularFile := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(regularFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	err := RemovePartitions([]string{dir})
+	if !errors.Is(err, ErrNotPartition) {
+		t.Fatalf("expected ErrNotPartition, got: %v", err)
+	}
+
+	if _, err := os.Lstat(link); err != nil {
+		t.Errorf("symlink was removed without --force: %v", err)
+	}
+
+	// With a source directory, Force only removes the symlinks pointing into it
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := os.Mkdir(sourceDir, os.ModePerm); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	sourceFile := filepath.Join(sourceDir, "file.txt")
+	if err := os.WriteFile(sourceFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	sourceLink := filepath.Join(dir, "source.txt")
+	if err := os.Symlink(sourceFile, sourceLink); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	if err := RemovePartitionsWithConfig(PartitionConfig{SourceDir: sourceDir, OutputDirs: []string{dir}, Force: true}); err != nil {
+		t.Fatalf("RemovePartitionsWithConfig with Force failed: %v", err)
+	}
+
+	if _, err := os.Lstat(sourceLink); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected symlink into the source to be removed with Force, got: %v", err)
+	}
+
+	if _, err := os.Lstat(link); err != nil {
+		t.Errorf("symlink pointing outside the source was removed with Force: %v", err)
+	}
+
+	if err := RemovePartitionsWithConfig(PartitionConfig{OutputDirs: []string{dir}, Force: true}); err != nil {
+		t.Fatalf("RemovePartitionsWithConfig with Force failed: %v", err)
+	}
+
+	if _, err := os.Lstat(link); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected symlink to be removed with Force, got: %v", err)
+	}
+
+	if _, err := os.Stat(regularFile); err != nil {
+		t.Errorf("regular file was removed with Force: %v", err)
+	}
+}
+
+func TestRemovePartitionsMissingDirectory(t *testing.T) {
+	config := PartitionConfig{OutputDirs: []string{filepath.Join(t.TempDir(), "missing")}}
+	if err := RemovePartitionsWithConfig(config); err != nil {
+		t.Errorf("expected missing partitions to be ignored, got: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package trc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// VerifyReport lists what VerifyPartitions found.
+type VerifyReport struct {
+	Checked  int             // Links listed in the manifests
+	Problems []VerifyProblem // Links that no longer match their manifest entry
+}
+
+// VerifyProblem describes a link that no longer matches its manifest entry.
+type VerifyProblem struct {
+	Path string // Full path of the link
+	Err  error  // What is wrong with it
+}
+
+func (p VerifyProblem) Error() string {
+	return p.Path + ": " + p.Err.Error()
+}
+
+// OK reports whether every link matched its manifest entry.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyPartitions checks every link listed in the manifests of config.OutputDirs with the
+// Linker of the link mode the partition was made with: symlinks must still point to their
+// existing source, hard links must still share it, and copies and moved files must be unchanged.
+// Directories without a manifest are reported with ErrNotPartition.
+func VerifyPartitions(config PartitionConfig) (*VerifyReport, error) {
+	if len(config.OutputDirs) == 0 {
+		return nil, errors.New("at least one output directory is required")
+	}
+
+	report := &VerifyReport{}
+	for _, dir := range config.OutputDirs {
+		manifest, err := ReadManifest(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("cannot verify %s: %w", dir, ErrNotPartition)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		linker, err := newLinker(manifest.Mode, manifest.Config.RelativeLinks)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, link := range manifest.Links {
+			path := filepath.Join(dir, filepath.FromSlash(link.Path))
+			report.Checked++
+
+			if err := linker.Verify(path, link); err != nil {
+				report.Problems = append(report.Problems, VerifyProblem{Path: path, Err: err})
+			}
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,4 @@
+package trc
+
+// Version is the version of trc, recorded in every partition manifest.
+const Version = "v0.0.1"
@@ -0,0 +1,370 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// sourceFile is a file found while walking the source tree.
+type sourceFile struct {
+	path     string      // Path of the file inside the source tree, which may go through followed symlinks
+	target   string      // Real file behind path when it differs, set when following symlinks
+	info     fs.FileInfo // Information about the file; about its target when it is a followed symlink
+	category string      // MIME category such as "image", set when the walk detects types
+}
+
+// walkDir is a directory of the source tree. It is read by one of the walk workers, which close
+// done once entries and err are set.
+type walkDir struct {
+	path     string
+	realPath string
+	id       fileID // Only set when following symlinks
+	parent   *walkDir
+
+	entries []walkEntry // In lexical order
+	err     error       // Error that stopped reading the directory after entries
+	done    chan struct{}
+}
+
+// walkEntry is either a file or a subdirectory to descend into.
+type walkEntry struct {
+	file sourceFile
+	dir  *walkDir
+}
+
+// sourceWalker walks the source tree with a pool of workers that read directories concurrently,
+// while the files are handed to the caller one at a time in the lexical, depth-first order of a
+// sequential walk, whatever the number of workers.
+//
+// Manifest and VCS directories are skipped, and so are the directories and files left out by the
+// filter. Symlinks are reported like plain files unless the filter follows them, in which case
+// symlinked directories are walked as well and every file is reported with its real path.
+// Directories are identified by device and inode, so each one is reported only once and symlink
+// cycles end.
+type sourceWalker struct {
+	ctx         context.Context
+	sourceDir   string
+	filter      *fileFilter
+	detectTypes bool
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []*walkDir // Directories waiting for a worker
+	pending  int        // Directories queued or being read
+	buffered int        // Directories read but not emitted yet
+	waiting  *walkDir   // Directory emit is waiting for
+	stopped  bool
+}
+
+// walkReadAhead is the number of directories the workers may read before emit gets to them.
+// Past it they only read the directory emit is waiting for, which bounds the memory of the walk.
+const walkReadAhead = 1024
+
+// walkSource calls visit for every file of the source tree selected by the filter, in lexical
+// order. With detectTypes set, empty files and files whose MIME type cannot be detected are left
+// out and the others are reported with their category. A nil filter selects every file. The walk
+// stops with the error of ctx once it is cancelled.
+func walkSource(ctx context.Context, sourceDir string, filter *fileFilter, detectTypes bool, visit func(file sourceFile) error) error {
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", sourceDir)
+	}
+
+	root := &walkDir{path: sourceDir, realPath: sourceDir, done: make(chan struct{})}
+	if filter.followsSymlinks() {
+		if root.id, err = newFileID(sourceDir, info); err != nil {
+			return err
+		}
+	}
+
+	if err := filter.enterDir(sourceDir); err != nil {
+		return err
+	}
+
+	w := &sourceWalker{ctx: ctx, sourceDir: sourceDir, filter: filter, detectTypes: detectTypes}
+	w.cond = sync.NewCond(&w.mu)
+	w.push(root)
+
+	var wg sync.WaitGroup
+	for range filter.walkWorkers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := w.pop(); dir != nil; dir = w.pop() {
+				w.read(dir)
+				w.finish()
+			}
+		}()
+	}
+
+	err = w.emit(root, make(map[fileID]bool), visit)
+
+	// Stop the workers early if the walk failed, and wait for them either way
+	w.mu.Lock()
+	w.stopped = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	wg.Wait()
+
+	return err
+}
+
+func (w *sourceWalker) push(dir *walkDir) {
+	w.mu.Lock()
+	w.queue = append(w.queue, dir)
+	w.pending++
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+// pop returns the next directory to read, or nil once the walk is over.
+func (w *sourceWalker) pop() *walkDir {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		if w.stopped || w.pending == 0 {
+			return nil
+		}
+
+		if len(w.queue) > 0 && w.buffered < walkReadAhead {
+			// Read the most recently found directory first, which keeps the workers close to
+			// the depth-first order emit consumes the directories in
+			dir := w.queue[len(w.queue)-1]
+			w.queue = w.queue[:len(w.queue)-1]
+			return dir
+		}
+
+		if i := slices.Index(w.queue, w.waiting); i >= 0 {
+			w.queue = slices.Delete(w.queue, i, i+1)
+			return w.waiting
+		}
+
+		w.cond.Wait()
+	}
+}
+
+// finish marks a directory returned by pop as read.
+func (w *sourceWalker) finish() {
+	w.mu.Lock()
+	w.pending--
+	w.buffered++
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// wait blocks until dir was read, letting the workers know emit needs it.
+func (w *sourceWalker) wait(dir *walkDir) {
+	w.mu.Lock()
+	w.waiting = dir
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	<-dir.done
+}
+
+// emitted marks a directory as handed to visit.
+func (w *sourceWalker) emitted() {
+	w.mu.Lock()
+	w.buffered--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// read lists a directory, deciding which of its subdirectories to descend into and which of its
+// files are selected, and queues the subdirectories for the other workers. With ContinueOnError
+// the entries that cannot be read are skipped instead of stopping the walk.
+func (w *sourceWalker) read(dir *walkDir) {
+	defer close(dir.done)
+
+	start := time.Now()
+	entries, err := os.ReadDir(dir.path)
+	if err != nil {
+		// Go on with the entries read before the error, if any
+		if dir.err = w.filter.skip(err); dir.err != nil {
+			return
+		}
+	}
+	logSlow(w.filter.logger(), start, "slow directory read", "path", dir.path, "entries", len(entries))
+
+	var subdirs []*walkDir
+	defer func() {
+		// Push in reverse, so the first subdirectory is popped first
+		for i := len(subdirs) - 1; i >= 0; i-- {
+			w.push(subdirs[i])
+		}
+	}()
+
+	for _, entry := range entries {
+		path := filepath.Join(dir.path, entry.Name())
+		realPath := filepath.Join(dir.realPath, entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			if dir.err = w.filter.skip(err); dir.err != nil {
+				return
+			}
+			continue
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 && w.filter.followsSymlinks() {
+			if realPath, err = filepath.EvalSymlinks(path); err != nil {
+				// A dangling symlink has no real file to link to
+				w.filter.logger().Debug("skipped dangling symlink", "path", path, "error", err)
+				continue
+			}
+
+			if info, err = os.Stat(realPath); err != nil {
+				if dir.err = w.filter.skip(err); dir.err != nil {
+					return
+				}
+				continue
+			}
+		}
+
+		if info.IsDir() {
+			subdir, err := w.subdir(dir, path, realPath, info)
+			if err != nil {
+				if dir.err = w.filter.skip(err); dir.err != nil {
+					return
+				}
+				continue
+			}
+
+			if subdir != nil {
+				dir.entries = append(dir.entries, walkEntry{dir: subdir})
+				subdirs = append(subdirs, subdir)
+			}
+			continue
+		}
+
+		file := sourceFile{path: path, info: info}
+		if realPath != path {
+			file.target = realPath
+		}
+
+		if w.inspect(&file) {
+			dir.entries = append(dir.entries, walkEntry{file: file})
+		}
+	}
+}
+
+// subdir returns the subdirectory to descend into at path, or nil if it is skipped.
+func (w *sourceWalker) subdir(parent *walkDir, path, realPath string, info fs.FileInfo) (*walkDir, error) {
+	if isManifestDir(w.sourceDir, path, true) || isVCSDir(w.sourceDir, path, true) {
+		return nil, nil
+	}
+
+	subdir := &walkDir{path: path, realPath: realPath, parent: parent, done: make(chan struct{})}
+	if w.filter.followsSymlinks() {
+		id, err := newFileID(realPath, info)
+		if err != nil {
+			return nil, err
+		}
+
+		// A symlink back to one of its own ancestors would never end
+		for ancestor := parent; ancestor != nil; ancestor = ancestor.parent {
+			if ancestor.id == id {
+				w.filter.logger().Debug("skipped symlink cycle", "path", path, "target", realPath)
+				return nil, nil
+			}
+		}
+		subdir.id = id
+	}
+
+	if err := w.filter.enterDir(path); err != nil {
+		if err == filepath.SkipDir {
+			w.filter.logger().Debug("skipped directory", "path", path, "reason", "excluded")
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return subdir, nil
+}
+
+// inspect reports whether a file is selected, detecting its MIME type if the walk asks for it.
+func (w *sourceWalker) inspect(file *sourceFile) bool {
+	log := w.filter.logger()
+	if !w.filter.includes(file.path) {
+		log.Debug("skipped file", "path", file.path, "reason", "excluded")
+		return false
+	}
+
+	if !w.filter.selects(file.path, file.info) {
+		log.Debug("skipped file", "path", file.path, "reason", "outside the kind, size or time limits")
+		return false
+	}
+
+	if !w.detectTypes {
+		return true
+	}
+
+	if file.info.Size() == 0 {
+		log.Debug("skipped file", "path", file.path, "reason", "empty, no MIME type to detect")
+		return false
+	}
+
+	// Detect MIME type using third-party library
+	start := time.Now()
+	mtype, err := mimetype.DetectFile(file.path)
+	if err != nil {
+		log.Warn("skipped file, failed to detect its MIME type", "path", file.path, "error", err)
+		return false
+	}
+	logSlow(log, start, "slow MIME detection", "path", file.path, "size", file.info.Size())
+
+	// Extract the category (e.g., "image", "video", etc.)
+	mainType := mtype.String()
+	file.category = mainType[:strings.Index(mainType, "/")]
+	return true
+}
+
+// emit hands the files of dir and its subdirectories to visit in depth-first order, waiting for
+// the workers to read each directory. Directories already emitted through another symlink are
+// skipped.
+func (w *sourceWalker) emit(dir *walkDir, visited map[fileID]bool, visit func(file sourceFile) error) error {
+	w.wait(dir)
+	defer w.emitted()
+
+	if w.filter.followsSymlinks() {
+		if visited[dir.id] {
+			return nil
+		}
+		visited[dir.id] = true
+	}
+
+	for _, entry := range dir.entries {
+		err := w.ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		if entry.dir != nil {
+			err = w.emit(entry.dir, visited, visit)
+		} else if err = visit(entry.file); err == nil {
+			w.filter.walked(entry.file)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// Emitted directories are not needed anymore, let the garbage collector reclaim them
+	dir.entries = nil
+	return dir.err
+}
@@ -0,0 +1,154 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFollowSymlinks(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceDir := filepath.Join(tempDir, "source")
+	datasetDir := filepath.Join(tempDir, "dataset")
+	for _, dir := range []string{sourceDir, datasetDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(sourceDir, "a.txt"), filepath.Join(datasetDir, "b.txt"), filepath.Join(tempDir, "c.txt")} {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	symlinks := map[string]string{
+		filepath.Join(sourceDir, "data"):     datasetDir,                        // Linked-in directory
+		filepath.Join(sourceDir, "link.txt"): filepath.Join(tempDir, "c.txt"),   // Linked-in file
+		filepath.Join(sourceDir, "self"):     ".",                               // Cycle back to the source directory
+		filepath.Join(datasetDir, "loop"):    "..",                              // Leads back to the already walked directories
+		filepath.Join(sourceDir, "dangling"): filepath.Join(tempDir, "missing"), // Nothing to link to
+	}
+	for link, target := range symlinks {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		follow   bool
+		expected map[string]string // link path -> source
+	}{
+		{
+			name:   "Symlinks are linked as files",
+			follow: false,
+			expected: map[string]string{
+				"a.txt":    filepath.Join(sourceDir, "a.txt"),
+				"dangling": filepath.Join(sourceDir, "dangling"),
+				"data":     filepath.Join(sourceDir, "data"),
+				"link.txt": filepath.Join(sourceDir, "link.txt"),
+				"self":     filepath.Join(sourceDir, "self"),
+			},
+		},
+		{
+			name:   "Symlinks are followed to their real files",
+			follow: true,
+			expected: map[string]string{
+				"a.txt":           filepath.Join(sourceDir, "a.txt"),
+				"data/b.txt":      filepath.Join(datasetDir, "b.txt"),
+				"data/loop/c.txt": filepath.Join(tempDir, "c.txt"),
+				"link.txt":        filepath.Join(tempDir, "c.txt"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := Plan(PartitionConfig{
+				SourceDir:      sourceDir,
+				OutputDirs:     []string{filepath.Join(t.TempDir(), "out")},
+				ByFile:         true,
+				PreserveTree:   true,
+				FollowSymlinks: tt.follow,
+			})
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+
+			got := make(map[string]string)
+			for _, link := range plan.Partitions[0].Links {
+				got[link.Link] = link.Source
+			}
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("planned links = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWalkIsDeterministic(t *testing.T) {
+	sourceDir := t.TempDir()
+	for i := range 20 {
+		dir := filepath.Join(sourceDir, fmt.Sprintf("dir%d", i), fmt.Sprintf("sub%d", i%3))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := range 10 {
+			for _, path := range []string{filepath.Join(dir, fmt.Sprintf("file%d.txt", j)), filepath.Join(filepath.Dir(dir), fmt.Sprintf("file%d.log", j))} {
+				if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "dir7", TrcIgnoreFile), []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sequential walk gives the expected order
+	var expected []string
+	err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() != TrcIgnoreFile && (filepath.Base(filepath.Dir(path)) != "dir7" || filepath.Ext(path) != ".log") {
+			expected = append(expected, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 2, 16} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			filter, err := newFileFilter(PartitionConfig{SourceDir: sourceDir, WalkWorkers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := collectFilesWithSize(context.Background(), sourceDir, filter)
+			if err != nil {
+				t.Fatalf("collectFilesWithSize failed: %v", err)
+			}
+
+			var got []string
+			for _, file := range files {
+				got = append(got, file.path)
+			}
+
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("walk order differs from a sequential walk:\ngot  %v\nwant %v", got, expected)
+			}
+		})
+	}
+}